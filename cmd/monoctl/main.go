@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	m "github.com/orangootan/monolith/pkg/monolith"
+)
+
+var (
+	addr     = flag.String("addr", "127.0.0.1:3003", "admin endpoint of the dispatcher")
+	asJSON   = flag.Bool("json", false, "print results as JSON")
+	identity = flag.String("identity", "", "identity to sign commands with, the secret is read from MONOCTL_SECRET")
+	certFile = flag.String("cert", "", "client certificate for TLS")
+	keyFile  = flag.String("key", "", "client key for TLS")
+	caFile   = flag.String("ca", "", "CA certificate to verify the dispatcher, enables TLS")
+)
+
+func dial() (*m.AdminClient, error) {
+	if *caFile == "" {
+		return m.DialAdmin(*addr)
+	}
+	ca, err := os.ReadFile(*caFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		RootCAs: x509.NewCertPool(),
+	}
+	if !config.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %v", *caFile)
+	}
+	if *certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return m.DialAdminTLS(*addr, config)
+}
+
+type labels map[string]string
+
+func (l labels) String() string {
+	var pairs []string
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labels) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("label %q is not in key=value form", s)
+	}
+	l[k] = v
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("monoctl: ")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: monoctl [flags] COMMAND [command flags]")
+		fmt.Fprintln(flag.CommandLine.Output(), "commands: services, clients, add, remove, drain, undrain")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var e m.EndPointInfo
+	l := labels{}
+	switch command {
+	case "clients":
+	case "services":
+		fs.StringVar(&e.Namespace, "namespace", "", "namespace to list (default: all)")
+	case "add":
+		fs.StringVar(&e.Namespace, "namespace", m.DefaultNamespace, "namespace of the service")
+		fs.StringVar(&e.Service, "service", "", "service name")
+		fs.StringVar(&e.Version, "version", "", "service version")
+		fs.StringVar(&e.Address, "address", "", "endpoint address")
+		fs.BoolVar(&e.Draining, "draining", false, "add the endpoint in draining state")
+		fs.Var(l, "label", "endpoint label as key=value (repeatable)")
+	case "remove", "drain", "undrain":
+		fs.StringVar(&e.Namespace, "namespace", "", "namespace of the service (default: any)")
+		fs.StringVar(&e.Service, "service", "", "service name (default: any)")
+		fs.StringVar(&e.Version, "version", "", "service version (default: any)")
+		fs.StringVar(&e.Address, "address", "", "endpoint address")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	_ = fs.Parse(args)
+	if len(l) != 0 {
+		e.Labels = l
+	}
+	a, err := dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer a.Close()
+	if *identity != "" {
+		a.SetCredentials(*identity, []byte(os.Getenv("MONOCTL_SECRET")))
+	}
+	switch command {
+	case "services":
+		endPoints, err := a.EndPoints(e.Namespace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printEndPoints(endPoints)
+	case "clients":
+		connections, err := a.Connections()
+		if err != nil {
+			log.Fatal(err)
+		}
+		printConnections(connections)
+	case "add":
+		err = a.Add(e)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printChanged(1)
+	case "remove":
+		n, err := a.Remove(e)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printChanged(n)
+	case "drain", "undrain":
+		n, err := a.Drain(e, command == "drain")
+		if err != nil {
+			log.Fatal(err)
+		}
+		printChanged(n)
+	}
+}
+
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+func printEndPoints(endPoints []m.EndPointInfo) {
+	if *asJSON {
+		if endPoints == nil {
+			endPoints = []m.EndPointInfo{}
+		}
+		printJSON(endPoints)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tSERVICE\tVERSION\tADDRESS\tSTATE\tLAST SEEN\tLABELS")
+	for _, e := range endPoints {
+		version := e.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", e.Namespace, e.Service, version, e.Address, state(e), ago(e.LastSeen), labels(e.Labels))
+	}
+	w.Flush()
+}
+
+func state(e m.EndPointInfo) string {
+	var states []string
+	if !e.Healthy {
+		states = append(states, "unhealthy")
+	}
+	if e.Draining {
+		states = append(states, "draining")
+	}
+	if !e.Verified {
+		states = append(states, "unverified")
+	}
+	if len(states) == 0 {
+		return "serving"
+	}
+	return strings.Join(states, ",")
+}
+
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func printConnections(connections []m.ConnectionInfo) {
+	if *asJSON {
+		if connections == nil {
+			connections = []m.ConnectionInfo{}
+		}
+		printJSON(connections)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tREMOTE\tLOCAL\tCONNECTED\tWATCHING")
+	for _, c := range connections {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", c.Kind, c.Remote, c.Local, ago(c.Since), strings.Join(c.Watching, ","))
+	}
+	w.Flush()
+}
+
+func printChanged(n int) {
+	if *asJSON {
+		printJSON(map[string]int{"changed": n})
+		return
+	}
+	fmt.Printf("%v endpoint(s) changed\n", n)
+}
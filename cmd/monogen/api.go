@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"go/ast"
+	"go/types"
+	"sort"
+)
+
+type API map[string]ServiceAPI
+
+type ServiceAPI struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	Methods []MethodAPI `json:"methods"`
+	Hash    string      `json:"hash,omitempty"`
+}
+
+type MethodAPI struct {
+	Name    string     `json:"name"`
+	Params  []ValueAPI `json:"params"`
+	Results []ValueAPI `json:"results"`
+}
+
+type ValueAPI struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func qualifiedType(expr ast.Expr, imports Imports) string {
+	switch e := expr.(type) {
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok {
+			if p, ok := imports[x.Name]; ok {
+				return p + "." + e.Sel.Name
+			}
+		}
+	case *ast.StarExpr:
+		return "*" + qualifiedType(e.X, imports)
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return "[]" + qualifiedType(e.Elt, imports)
+		}
+		return "[" + types.ExprString(e.Len) + "]" + qualifiedType(e.Elt, imports)
+	case *ast.MapType:
+		return "map[" + qualifiedType(e.Key, imports) + "]" + qualifiedType(e.Value, imports)
+	case *ast.Ellipsis:
+		return "..." + qualifiedType(e.Elt, imports)
+	}
+	return types.ExprString(expr)
+}
+
+func (vg ValueGroup) QualifiedType() string {
+	return qualifiedType(vg.Expr, vg.Imports)
+}
+
+func valuesAPI(vgs []ValueGroup, unnamed string) []ValueAPI {
+	ns := newNameSelector()
+	var values []ValueAPI
+	for _, vg := range flatten(vgs) {
+		var name string
+		if len(vg.Names) == 0 {
+			name = ns.New(unnamed)
+		} else {
+			name = ns.New(toTitle.String(vg.Names[0]))
+		}
+		values = append(values, ValueAPI{
+			Name: name,
+			Type: vg.QualifiedType(),
+		})
+	}
+	return values
+}
+
+func methodAPI(f Function) MethodAPI {
+	return MethodAPI{
+		Name:    f.Name,
+		Params:  valuesAPI(f.Params, "P"),
+		Results: valuesAPI(f.Results, "R"),
+	}
+}
+
+func (s ServiceAPI) method(name string) (MethodAPI, bool) {
+	for _, m := range s.Methods {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return MethodAPI{}, false
+}
+
+func (s ServiceAPI) finish() ServiceAPI {
+	sort.Slice(s.Methods, func(i, k int) bool {
+		return s.Methods[i].Name < s.Methods[k].Name
+	})
+	s.Hash = ""
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	s.Hash = hex.EncodeToString(sum[:16])
+	return s
+}
+
+func interfaceAPI(i Interface) ServiceAPI {
+	s := ServiceAPI{
+		Name: i.Name,
+	}
+	s.Version, _ = i.version()
+	if i.ID != nil {
+		s.ID = i.ID.QualifiedType()
+	}
+	for _, m := range i.Methods {
+		s.Methods = append(s.Methods, methodAPI(m))
+	}
+	return s.finish()
+}
+
+func serviceAPI(service *Service) ServiceAPI {
+	s := ServiceAPI{
+		Name: service.Type.Name,
+	}
+	s.Version, _ = service.Type.version()
+	if id := service.Constructor.instanceID(); id != nil {
+		s.ID = id.QualifiedType()
+	}
+	for _, m := range service.Methods {
+		s.Methods = append(s.Methods, methodAPI(m.Function))
+	}
+	return s.finish()
+}
+
+func createAPI(fs []File, sm ServiceMap) API {
+	api := make(API)
+	for _, f := range fs {
+		for _, i := range f.Interfaces {
+			api[i.Name] = interfaceAPI(i)
+		}
+	}
+	for _, service := range sm {
+		api[service.Type.Name] = serviceAPI(service)
+	}
+	return api
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Change struct {
+	Service     string `json:"service"`
+	Method      string `json:"method,omitempty"`
+	Breaking    bool   `json:"breaking"`
+	Description string `json:"description"`
+}
+
+type Report struct {
+	Old      string   `json:"old"`
+	New      string   `json:"new"`
+	Breaking bool     `json:"breaking"`
+	Changes  []Change `json:"changes"`
+}
+
+func runCompat(args []string) {
+	fs := flag.NewFlagSet("compat", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: monogen compat [flags] OLD NEW")
+		fmt.Fprintln(fs.Output(), "OLD and NEW are directories, or git revisions when -git is set.")
+		fs.PrintDefaults()
+	}
+	useGit := fs.Bool("git", false, "treat OLD and NEW as git revisions")
+	dir := fs.String("dir", ".", "package directory to read from each revision (with -git)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	load := loadDir
+	if *useGit {
+		load = func(rev string) (API, error) {
+			return loadGit(rev, *dir)
+		}
+	}
+	oldAPI, err := load(fs.Arg(0))
+	if err != nil {
+		fatalf("%v: %v", fs.Arg(0), err)
+	}
+	newAPI, err := load(fs.Arg(1))
+	if err != nil {
+		fatalf("%v: %v", fs.Arg(1), err)
+	}
+	report := Report{
+		Old:     fs.Arg(0),
+		New:     fs.Arg(1),
+		Changes: compareAPI(oldAPI, newAPI),
+	}
+	for _, c := range report.Changes {
+		report.Breaking = report.Breaking || c.Breaking
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	} else {
+		printReport(report)
+	}
+	if report.Breaking {
+		os.Exit(1)
+	}
+}
+
+func fatalf(format string, v ...any) {
+	fmt.Fprintf(os.Stderr, "monogen: "+format+"\n", v...)
+	os.Exit(2)
+}
+
+func printReport(r Report) {
+	for _, c := range r.Changes {
+		kind := "compatible"
+		if c.Breaking {
+			kind = "BREAKING"
+		}
+		name := c.Service
+		if c.Method != "" {
+			name += "." + c.Method
+		}
+		fmt.Printf("%-10v %v: %v\n", kind, name, c.Description)
+	}
+	breaking := 0
+	for _, c := range r.Changes {
+		if c.Breaking {
+			breaking++
+		}
+	}
+	fmt.Printf("%v changes, %v breaking\n", len(r.Changes), breaking)
+}
+
+func parseSources(sources map[string][]byte) (API, error) {
+	set := token.NewFileSet()
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var files []File
+	for _, name := range names {
+		f, err := parser.ParseFile(set, name, sources[name], parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parseFile(f).filter()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	sm, err := createServiceMap(files)
+	if err != nil {
+		return nil, err
+	}
+	return createAPI(files, sm), nil
+}
+
+func isSource(name string) bool {
+	return strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go")
+}
+
+func loadDir(dir string) (API, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string][]byte)
+	for _, e := range entries {
+		if e.IsDir() || !isSource(e.Name()) {
+			continue
+		}
+		name := filepath.Join(dir, e.Name())
+		sources[name], err = os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseSources(sources)
+}
+
+func loadGit(rev, dir string) (API, error) {
+	out, err := exec.Command("git", "ls-tree", "--name-only", rev, filepath.ToSlash(filepath.Clean(dir))+"/").Output()
+	if err != nil {
+		return nil, gitError(err)
+	}
+	sources := make(map[string][]byte)
+	for _, name := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if !isSource(name) {
+			continue
+		}
+		sources[name], err = exec.Command("git", "show", rev+":./"+name).Output()
+		if err != nil {
+			return nil, gitError(err)
+		}
+	}
+	return parseSources(sources)
+}
+
+func gitError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) != 0 {
+		return fmt.Errorf("git: %v", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+func compareAPI(oldAPI, newAPI API) []Change {
+	var changes []Change
+	for _, name := range sortedNames(oldAPI, newAPI) {
+		o, inOld := oldAPI[name]
+		n, inNew := newAPI[name]
+		switch {
+		case !inNew:
+			changes = append(changes, Change{Service: name, Breaking: true, Description: "service removed"})
+		case !inOld:
+			changes = append(changes, Change{Service: name, Description: "service added"})
+		default:
+			changes = append(changes, compareService(o, n)...)
+		}
+	}
+	return changes
+}
+
+func sortedNames(apis ...API) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, api := range apis {
+		for name := range api {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func compareService(o, n ServiceAPI) []Change {
+	var changes []Change
+	if o.Version != n.Version {
+		changes = append(changes, Change{
+			Service:     o.Name,
+			Description: fmt.Sprintf("version changed from %q to %q", o.Version, n.Version),
+		})
+	}
+	if o.ID != n.ID {
+		changes = append(changes, Change{
+			Service:     o.Name,
+			Breaking:    true,
+			Description: fmt.Sprintf("instance ID changed from %v to %v", describeID(o.ID), describeID(n.ID)),
+		})
+	}
+	for _, m := range o.Methods {
+		nm, ok := n.method(m.Name)
+		if !ok {
+			changes = append(changes, Change{Service: o.Name, Method: m.Name, Breaking: true, Description: "method removed"})
+			continue
+		}
+		for _, c := range compareMethod(m, nm) {
+			c.Service = o.Name
+			c.Method = m.Name
+			changes = append(changes, c)
+		}
+	}
+	for _, m := range n.Methods {
+		if _, ok := o.method(m.Name); !ok {
+			changes = append(changes, Change{Service: o.Name, Method: m.Name, Description: "method added"})
+		}
+	}
+	return changes
+}
+
+func describeID(id string) string {
+	if id == "" {
+		return "none (singleton)"
+	}
+	return id
+}
+
+func compareMethod(o, n MethodAPI) []Change {
+	var changes []Change
+	add := func(breaking bool, format string, v ...any) {
+		changes = append(changes, Change{Breaking: breaking, Description: fmt.Sprintf(format, v...)})
+	}
+	compareValues := func(kind string, ov, nv []ValueAPI) {
+		for i := 0; i < len(ov) && i < len(nv); i++ {
+			if ov[i].Name != nv[i].Name {
+				add(true, "%v %v renamed to %v (values are encoded by name)", kind, ov[i].Name, nv[i].Name)
+			}
+			if ov[i].Type != nv[i].Type {
+				add(true, "%v %v changed type from %v to %v", kind, nv[i].Name, ov[i].Type, nv[i].Type)
+			}
+		}
+	}
+	compareValues("parameter", o.Params, n.Params)
+	for i := len(o.Params); i < len(n.Params); i++ {
+		add(true, "parameter %v %v added", n.Params[i].Name, n.Params[i].Type)
+	}
+	for i := len(n.Params); i < len(o.Params); i++ {
+		add(true, "parameter %v %v removed", o.Params[i].Name, o.Params[i].Type)
+	}
+	compareValues("result", o.Results, n.Results)
+	for i := len(o.Results); i < len(n.Results); i++ {
+		add(false, "result %v %v added", n.Results[i].Name, n.Results[i].Type)
+	}
+	for i := len(n.Results); i < len(o.Results); i++ {
+		add(true, "result %v %v removed", o.Results[i].Name, o.Results[i].Type)
+	}
+	return changes
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func parseInterface(t *testing.T, body string) API {
+	t.Helper()
+	source := "package math\n\n" + body
+	api, err := parseSources(map[string][]byte{"math.go": []byte(source)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestCompareAPI(t *testing.T) {
+	const base = "//monolith:service\ntype Math interface {\n\tAdd(a, b int) (sum int)\n}\n"
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		changes []Change
+	}{
+		{
+			name: "unchanged",
+			old:  base,
+			new:  base,
+		},
+		{
+			name: "service added",
+			old:  "",
+			new:  base,
+			changes: []Change{
+				{Service: "Math", Description: "service added"},
+			},
+		},
+		{
+			name: "service removed",
+			old:  base,
+			new:  "",
+			changes: []Change{
+				{Service: "Math", Breaking: true, Description: "service removed"},
+			},
+		},
+		{
+			name: "method added",
+			old:  base,
+			new:  "//monolith:service\ntype Math interface {\n\tAdd(a, b int) (sum int)\n\tNeg(a int) int\n}\n",
+			changes: []Change{
+				{Service: "Math", Method: "Neg", Description: "method added"},
+			},
+		},
+		{
+			name: "method removed",
+			old:  "//monolith:service\ntype Math interface {\n\tAdd(a, b int) (sum int)\n\tNeg(a int) int\n}\n",
+			new:  base,
+			changes: []Change{
+				{Service: "Math", Method: "Neg", Breaking: true, Description: "method removed"},
+			},
+		},
+		{
+			name: "parameter renamed",
+			old:  base,
+			new:  "//monolith:service\ntype Math interface {\n\tAdd(x, b int) (sum int)\n}\n",
+			changes: []Change{
+				{Service: "Math", Method: "Add", Breaking: true, Description: "parameter A renamed to X (values are encoded by name)"},
+			},
+		},
+		{
+			name: "parameter type changed",
+			old:  base,
+			new:  "//monolith:service\ntype Math interface {\n\tAdd(a int, b int64) (sum int)\n}\n",
+			changes: []Change{
+				{Service: "Math", Method: "Add", Breaking: true, Description: "parameter B changed type from int to int64"},
+			},
+		},
+		{
+			name: "parameter added",
+			old:  base,
+			new:  "//monolith:service\ntype Math interface {\n\tAdd(a, b, c int) (sum int)\n}\n",
+			changes: []Change{
+				{Service: "Math", Method: "Add", Breaking: true, Description: "parameter C int added"},
+			},
+		},
+		{
+			name: "parameter removed",
+			old:  base,
+			new:  "//monolith:service\ntype Math interface {\n\tAdd(a int) (sum int)\n}\n",
+			changes: []Change{
+				{Service: "Math", Method: "Add", Breaking: true, Description: "parameter B int removed"},
+			},
+		},
+		{
+			name: "result added",
+			old:  base,
+			new:  "//monolith:service\ntype Math interface {\n\tAdd(a, b int) (sum int, err error)\n}\n",
+			changes: []Change{
+				{Service: "Math", Method: "Add", Description: "result Err error added"},
+			},
+		},
+		{
+			name: "result removed",
+			old:  "//monolith:service\ntype Math interface {\n\tAdd(a, b int) (sum int, err error)\n}\n",
+			new:  base,
+			changes: []Change{
+				{Service: "Math", Method: "Add", Breaking: true, Description: "result Err error removed"},
+			},
+		},
+		{
+			name: "instance ID changed",
+			old:  base,
+			new:  "//monolith:service id=int\ntype Math interface {\n\tAdd(a, b int) (sum int)\n}\n",
+			changes: []Change{
+				{Service: "Math", Breaking: true, Description: "instance ID changed from string to int"},
+			},
+		},
+		{
+			name: "version changed",
+			old:  base,
+			new:  "//monolith:service version=1.1.0\ntype Math interface {\n\tAdd(a, b int) (sum int)\n}\n",
+			changes: []Change{
+				{Service: "Math", Description: `version changed from "" to "1.1.0"`},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := compareAPI(parseInterface(t, test.old), parseInterface(t, test.new))
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Fatalf("got %+v, want %+v", changes, test.changes)
+			}
+		})
+	}
+}
+
+func TestSchemaHash(t *testing.T) {
+	const base = "//monolith:service\ntype Math interface {\n\tAdd(a, b int) int\n\tNeg(a int) int\n}\n"
+	tests := []struct {
+		name string
+		api  string
+		same bool
+	}{
+		{
+			name: "methods reordered",
+			api:  "//monolith:service\ntype Math interface {\n\tNeg(a int) int\n\tAdd(a, b int) int\n}\n",
+			same: true,
+		},
+		{
+			name: "parameters grouped differently",
+			api:  "//monolith:service\ntype Math interface {\n\tAdd(a int, b int) int\n\tNeg(a int) int\n}\n",
+			same: true,
+		},
+		{
+			name: "parameter type changed",
+			api:  "//monolith:service\ntype Math interface {\n\tAdd(a, b int64) int\n\tNeg(a int) int\n}\n",
+		},
+		{
+			name: "parameter renamed",
+			api:  "//monolith:service\ntype Math interface {\n\tAdd(x, b int) int\n\tNeg(a int) int\n}\n",
+		},
+		{
+			name: "instance ID changed",
+			api:  "//monolith:service id=int\ntype Math interface {\n\tAdd(a, b int) int\n\tNeg(a int) int\n}\n",
+		},
+	}
+	hash := parseInterface(t, base)["Math"].Hash
+	if len(hash) != 32 {
+		t.Fatalf("got hash %q, want 32 hex digits", hash)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other := parseInterface(t, test.api)["Math"].Hash
+			if (other == hash) != test.same {
+				t.Fatalf("got hash %v for %v, same %v, want same %v", other, hash, other == hash, test.same)
+			}
+		})
+	}
+}
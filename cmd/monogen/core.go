@@ -1,19 +1,28 @@
 package main
 
+import "go/ast"
+
 var monolith = "github.com/orangootan/monolith/pkg/monolith"
 
+var monolithAlias = "m"
+
 type File struct {
 	Package
+	Imports    Imports
 	Types      []Type
 	Methods    []Method
 	Interfaces []Interface
 }
 
+type Imports map[string]string
+
 type Decl struct {
 	Comments []string
 	Name     string
 }
 
+type Directive map[string]string
+
 type Package struct {
 	Decl
 }
@@ -24,6 +33,7 @@ type Type struct {
 
 type Interface struct {
 	Decl
+	ID      *ValueGroup
 	Methods []Function
 }
 
@@ -39,14 +49,35 @@ type Function struct {
 }
 
 type ValueGroup struct {
-	Names []string
-	Type  string
+	Names   []string
+	Type    string
+	Expr    ast.Expr
+	Imports Imports
+}
+
+type ArgumentKind int
+
+const (
+	DependencyArgument ArgumentKind = iota
+	ContextArgument
+	IDArgument
+)
+
+type Argument struct {
+	Kind ArgumentKind
+	Name string
+	ValueGroup
+}
+
+type Constructor struct {
+	Method
+	Arguments []Argument
 }
 
 type Service struct {
 	Type        Type
 	Methods     []Method
-	Constructor *Method
+	Constructor *Constructor
 }
 
 type ServiceMap map[[2]string]*Service
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+type edit struct {
+	Op   byte
+	Line string
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func diffLines(a, b []string) []edit {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for k := len(b) - 1; k >= 0; k-- {
+			switch {
+			case a[i] == b[k]:
+				lcs[i][k] = lcs[i+1][k+1] + 1
+			case lcs[i+1][k] >= lcs[i][k+1]:
+				lcs[i][k] = lcs[i+1][k]
+			default:
+				lcs[i][k] = lcs[i][k+1]
+			}
+		}
+	}
+	var edits []edit
+	i, k := 0, 0
+	for i < len(a) || k < len(b) {
+		switch {
+		case i < len(a) && k < len(b) && a[i] == b[k]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			k++
+		case k == len(b) || i < len(a) && lcs[i+1][k] >= lcs[i][k+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[k]})
+			k++
+		}
+	}
+	return edits
+}
+
+func unifiedDiff(name, a, b string) string {
+	edits := diffLines(splitLines(a), splitLines(b))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %v\n+++ %v (regenerated)\n", name, name)
+	line := [2]int{1, 1}
+	for start := 0; start < len(edits); {
+		if edits[start].Op == ' ' {
+			line[0]++
+			line[1]++
+			start++
+			continue
+		}
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+		last := start
+		for i := start; i < len(edits) && i <= last+2*diffContext; i++ {
+			if edits[i].Op != ' ' {
+				last = i
+			}
+		}
+		end := last + diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+		from := [2]int{line[0] - (start - first), line[1] - (start - first)}
+		var count [2]int
+		var body strings.Builder
+		for _, e := range edits[first:end] {
+			if e.Op != '+' {
+				count[0]++
+			}
+			if e.Op != '-' {
+				count[1]++
+			}
+			body.WriteByte(e.Op)
+			body.WriteString(e.Line)
+			if !strings.HasSuffix(e.Line, "\n") {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		for side := range from {
+			// An empty range is numbered after the line it follows.
+			if count[side] == 0 {
+				from[side]--
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%v,%v +%v,%v @@\n", from[0], count[0], from[1], count[1])
+		sb.WriteString(body.String())
+		for _, e := range edits[start:end] {
+			if e.Op != '+' {
+				line[0]++
+			}
+			if e.Op != '-' {
+				line[1]++
+			}
+		}
+		start = end
+	}
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func numberedLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "l%d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	const header = "--- f.go\n+++ f.go (regenerated)\n"
+	tests := []struct {
+		name string
+		a, b string
+		diff string
+	}{
+		{
+			name: "identical",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			diff: header,
+		},
+		{
+			name: "changed line with context",
+			a:    numberedLines(10),
+			b:    strings.Replace(numberedLines(10), "l5\n", "x5\n", 1),
+			diff: header + "@@ -2,7 +2,7 @@\n l2\n l3\n l4\n-l5\n+x5\n l6\n l7\n l8\n",
+		},
+		{
+			name: "distant changes in separate hunks",
+			a:    numberedLines(20),
+			b:    strings.Replace(strings.Replace(numberedLines(20), "l2\n", "x2\n", 1), "l18\n", "", 1),
+			diff: header + "@@ -1,5 +1,5 @@\n l1\n-l2\n+x2\n l3\n l4\n l5\n" +
+				"@@ -15,6 +15,5 @@\n l15\n l16\n l17\n-l18\n l19\n l20\n",
+		},
+		{
+			name: "close changes in one hunk",
+			a:    numberedLines(10),
+			b:    strings.Replace(strings.Replace(numberedLines(10), "l3\n", "x3\n", 1), "l8\n", "x8\n", 1),
+			diff: header + "@@ -1,10 +1,10 @@\n l1\n l2\n-l3\n+x3\n l4\n l5\n l6\n l7\n-l8\n+x8\n l9\n l10\n",
+		},
+		{
+			name: "missing newline at end of file",
+			a:    "a\nb\n",
+			b:    "a\nb\nc",
+			diff: header + "@@ -1,2 +1,3 @@\n a\n b\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "new file",
+			a:    "",
+			b:    "a\nb\n",
+			diff: header + "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "emptied file",
+			a:    "a\n",
+			b:    "",
+			diff: header + "@@ -1,1 +0,0 @@\n-a\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := unifiedDiff("f.go", test.a, test.b); diff != test.diff {
+				t.Fatalf("got\n%v\nwant\n%v", diff, test.diff)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"go/ast"
+	"go/types"
+
 	j "github.com/dave/jennifer/jen"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -8,6 +11,35 @@ import (
 
 var toTitle = cases.Title(language.English)
 
+func typeCode(expr ast.Expr, imports Imports) j.Code {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return j.Id(e.Name)
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok {
+			if p, ok := imports[x.Name]; ok {
+				return j.Qual(p, e.Sel.Name)
+			}
+		}
+	case *ast.StarExpr:
+		return j.Op("*").Add(typeCode(e.X, imports))
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return j.Index().Add(typeCode(e.Elt, imports))
+		}
+		return j.Index(j.Id(types.ExprString(e.Len))).Add(typeCode(e.Elt, imports))
+	case *ast.MapType:
+		return j.Map(typeCode(e.Key, imports)).Add(typeCode(e.Value, imports))
+	case *ast.Ellipsis:
+		return j.Op("...").Add(typeCode(e.Elt, imports))
+	}
+	return j.Id(types.ExprString(expr))
+}
+
+func (vg ValueGroup) TypeCode() j.Code {
+	return typeCode(vg.Expr, vg.Imports)
+}
+
 func generateProxyMethod(proxy string, method Function) j.Code {
 	nsGlobal := newNameSelector()
 	nsParams := newNameSelector()
@@ -21,7 +53,7 @@ func generateProxyMethod(proxy string, method Function) j.Code {
 			nsGlobal.Add(name)
 			return j.Id(name)
 		})
-		return j.List(names...).Id(vg.Type)
+		return j.List(names...).Add(vg.TypeCode())
 	})
 	paramGroupsTitle := Map(method.Params, func(vg ValueGroup) j.Code {
 		names := Map(vg.Names, func(name string) j.Code {
@@ -29,14 +61,14 @@ func generateProxyMethod(proxy string, method Function) j.Code {
 			paramNamesTitle = append(paramNamesTitle, title)
 			return j.Id(title)
 		})
-		return j.List(names...).Id(vg.Type)
+		return j.List(names...).Add(vg.TypeCode())
 	})
 	resultGroups := Map(method.Results, func(vg ValueGroup) j.Code {
 		names := Map(vg.Names, func(name string) j.Code {
 			nsGlobal.Add(name)
 			return j.Id(name)
 		})
-		return j.List(names...).Id(vg.Type)
+		return j.List(names...).Add(vg.TypeCode())
 	})
 	resultGroupsTitle := Map(method.Results, func(vg ValueGroup) j.Code {
 		names := Map(vg.Names, func(name string) j.Code {
@@ -49,7 +81,7 @@ func generateProxyMethod(proxy string, method Function) j.Code {
 			names = append(names, j.Id(name))
 			resultNamesTitle = append(resultNamesTitle, name)
 		}
-		return j.List(names...).Id(vg.Type)
+		return j.List(names...).Add(vg.TypeCode())
 	})
 	results := Map(resultNamesTitle, func(name string) j.Code {
 		return j.Id("results").Dot(name)
@@ -79,21 +111,119 @@ func generateProxyMethod(proxy string, method Function) j.Code {
 	})
 }
 
+func generateGetter(i Interface) j.Code {
+	client := j.Id("client").Op("*").Qual(monolith, "Client")
+	options := j.Id("options").Op("...").Qual(monolith, "GetOption")
+	if i.ID == nil {
+		return j.Func().Id("Get"+i.Name).Params(client, options).Params(j.Id(i.Name), j.Id("error")).Block(
+			j.Return(j.Qual(monolith, "GetSingleton").Types(j.Id(i.Name)).Call(j.Id("client"), j.Id("options").Op("..."))))
+	}
+	return j.Func().Id("Get"+i.Name).Params(j.Id("id").Add(i.ID.TypeCode()), client, options).Params(j.Id(i.Name), j.Id("error")).Block(
+		j.Return(j.Qual(monolith, "Get").Types(j.Id(i.Name)).Call(j.Id("id"), j.Id("client"), j.Id("options").Op("..."))))
+}
+
+func generateSchema(api ServiceAPI) j.Code {
+	values := func(vs []ValueAPI) j.Code {
+		return j.Index().Qual(monolith, "ValueSchema").ValuesFunc(func(g *j.Group) {
+			for _, v := range vs {
+				g.Values(j.Dict{
+					j.Id("Name"): j.Lit(v.Name),
+					j.Id("Type"): j.Lit(v.Type),
+				})
+			}
+		})
+	}
+	return j.Qual(monolith, "Schema").Values(j.DictFunc(func(d j.Dict) {
+		d[j.Id("Service")] = j.Lit(api.Name)
+		if api.Version != "" {
+			d[j.Id("Version")] = j.Lit(api.Version)
+		}
+		if api.ID != "" {
+			d[j.Id("ID")] = j.Lit(api.ID)
+		}
+		d[j.Id("Hash")] = j.Lit(api.Hash)
+		d[j.Id("Methods")] = j.Index().Qual(monolith, "MethodSchema").ValuesFunc(func(g *j.Group) {
+			for _, m := range api.Methods {
+				g.Values(j.DictFunc(func(d j.Dict) {
+					d[j.Id("Name")] = j.Lit(m.Name)
+					if len(m.Params) != 0 {
+						d[j.Id("Params")] = values(m.Params)
+					}
+					if len(m.Results) != 0 {
+						d[j.Id("Results")] = values(m.Results)
+					}
+				}))
+			}
+		})
+	}))
+}
+
 func generateRegisterProxy(name string) j.Code {
 	return j.Qual(monolith, "RegisterProxy").Types(j.Id(name)).
 		Call(j.Func().Params(j.Id("i").Qual(monolith, "Instance")).Any().Block(
 			j.Return(j.Id(name + "Proxy").Call(j.Id("i")))))
 }
 
+func generateMustRegister(call j.Code) j.Code {
+	return j.If(j.Err().Op(":=").Add(call), j.Err().Op("!=").Nil()).Block(
+		j.Panic(j.Err()))
+}
+
+func generateInjections(g *j.Group, c *Constructor) []j.Code {
+	ns := newNameSelector()
+	for _, name := range []string{monolithAlias, "ctx", "rawID", "id", "method", "decode", "encode", "err", "instance"} {
+		ns.Add(name)
+	}
+	var args []j.Code
+	for _, a := range c.Arguments {
+		switch a.Kind {
+		case ContextArgument:
+			args = append(args, j.Id("ctx"))
+		case IDArgument:
+			args = append(args, j.Id("id"))
+		case DependencyArgument:
+			base := a.Name
+			if base == "" || base == "_" {
+				base = "dependency"
+			}
+			name := ns.New(base)
+			g.List(j.Id(name), j.Id("err")).Op(":=").Qual(monolith, "Inject").Types(a.TypeCode()).Call(j.Id("ctx"))
+			g.If(j.Id("err").Op("!=").Nil()).Block(j.Return())
+			args = append(args, j.Id(name))
+		}
+	}
+	return args
+}
+
+func generateConstructorCall(g *j.Group, c *Constructor) {
+	id := c.instanceID()
+	if id == nil {
+		instanceType := flatten(c.Results)[0].TypeCode()
+		g.List(j.Id("instance"), j.Id("err")).Op(":=").Qual(monolith, "Singleton").Call(
+			j.Id("ctx"),
+			j.Func().Params().Params(j.Id("instance").Add(instanceType), j.Id("err").Id("error")).BlockFunc(func(g1 *j.Group) {
+				args := generateInjections(g1, c)
+				g1.Return(j.Id(c.Name).Call(args...))
+			}))
+		g.If(j.Id("err").Op("!=").Nil()).Block(j.Return())
+		return
+	}
+	g.List(j.Id("id"), j.Id("err")).Op(":=").Qual(monolith, "DecodeID").Types(id.TypeCode()).Call(j.Id("rawID"))
+	g.If(j.Id("err").Op("!=").Nil()).Block(j.Return())
+	args := generateInjections(g, c)
+	g.List(j.Id("instance"), j.Id("err")).Op(":=").Id(c.Name).Call(args...)
+	g.If(j.Id("err").Op("!=").Nil()).Block(j.Return())
+}
+
 func generateTypeHandler(s *Service) j.Code {
 	return j.Func().Id(s.Type.Name+"Handler").Params(
-		j.Id("id").Id("string"),
+		j.Id("ctx").Qual("context", "Context"),
+		j.Id("rawID").Index().Byte(),
 		j.Id("method").Id("string"),
 		j.Id("decode").Func().Params(j.Id("params").Id("any")).Params(j.Id("error")),
 		j.Id("encode").Func().Params(j.Id("params").Id("any")).Params(j.Id("error")),
 	).Params(j.Id("err").Id("error")).BlockFunc(func(g *j.Group) {
-		g.List(j.Id("instance"), j.Id("err")).Op(":=").Id(s.Constructor.Name).Call(j.Id("id"))
-		g.If(j.Id("err").Op("!=").Nil()).Block(j.Return())
+		generateConstructorCall(g, s.Constructor)
 		g.Switch(j.Id("method")).BlockFunc(func(g1 *j.Group) {
 			for _, m := range s.Methods {
 				nsParams := newNameSelector()
@@ -106,7 +236,7 @@ func generateTypeHandler(s *Service) j.Code {
 						paramNamesTitle = append(paramNamesTitle, title)
 						return j.Id(title)
 					})
-					return j.List(names...).Id(vg.Type)
+					return j.List(names...).Add(vg.TypeCode())
 				})
 				resultGroupsTitle := Map(m.Results, func(vg ValueGroup) j.Code {
 					names := Map(vg.Names, func(name string) j.Code {
@@ -119,7 +249,7 @@ func generateTypeHandler(s *Service) j.Code {
 						names = append(names, j.Id(name))
 						resultNamesTitle = append(resultNamesTitle, name)
 					}
-					return j.List(names...).Id(vg.Type)
+					return j.List(names...).Add(vg.TypeCode())
 				})
 				params := Map(paramNamesTitle, func(name string) j.Code {
 					return j.Id("params").Dot(name)
@@ -143,7 +273,7 @@ func generateTypeHandler(s *Service) j.Code {
 
 func generateFile(s File, sm ServiceMap) *j.File {
 	f := j.NewFile(s.Package.Name)
-	f.ImportAlias(monolith, "m")
+	f.ImportAlias(monolith, monolithAlias)
 	for _, i := range s.Interfaces {
 		f.Type().Id(i.Name+"Proxy").Qual(monolith, "Instance")
 	}
@@ -151,10 +281,12 @@ func generateFile(s File, sm ServiceMap) *j.File {
 		f.Func().Id("init").Params().BlockFunc(func(g *j.Group) {
 			for _, i := range s.Interfaces {
 				g.Add(generateRegisterProxy(i.Name))
+				g.Qual(monolith, "RegisterProxySchema").Types(j.Id(i.Name)).Call(generateSchema(interfaceAPI(i)))
 			}
 		})
 	}
 	for _, i := range s.Interfaces {
+		f.Add(generateGetter(i))
 		for _, m := range i.Methods {
 			f.Add(generateProxyMethod(i.Name, m))
 		}
@@ -162,7 +294,13 @@ func generateFile(s File, sm ServiceMap) *j.File {
 	if len(s.Types) != 0 {
 		f.Func().Id("init").Params().BlockFunc(func(g *j.Group) {
 			for _, t := range s.Types {
-				g.Qual(monolith, "RegisterTypeHandler").Call(j.Lit(t.Name), j.Id(t.Name+"Handler"))
+				service := sm[[2]string{s.Package.Name, t.Name}]
+				if version, _ := t.version(); version != "" {
+					g.Add(generateMustRegister(j.Qual(monolith, "RegisterVersionedTypeHandler").Call(j.Lit(t.Name), j.Lit(version), j.Id(t.Name+"Handler"))))
+				} else {
+					g.Qual(monolith, "RegisterTypeHandler").Call(j.Lit(t.Name), j.Id(t.Name+"Handler"))
+				}
+				g.Add(generateMustRegister(j.Qual(monolith, "RegisterTypeSchema").Call(generateSchema(serviceAPI(service)))))
 			}
 		})
 	}
@@ -1,36 +1,120 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"go/parser"
 	"go/token"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 )
 
+var (
+	check  = flag.Bool("check", false, "report stale generated files instead of writing them")
+	diff   = flag.Bool("diff", false, "print differences between existing and regenerated files (implies -check)")
+	outDir = flag.String("out", "", "directory for generated files (default: next to the source file)")
+	suffix = flag.String("suffix", ".g", "suffix inserted before the extension of generated files")
+	alias  = flag.String("alias", "m", "package name of the monolith import in generated files")
+	schema = flag.Bool("schema", false, "also write the service schemas of each file as JSON")
+)
+
 func main() {
+	log.SetFlags(0)
+	log.SetPrefix("monogen: ")
+	if len(os.Args) > 1 && os.Args[1] == "compat" {
+		runCompat(os.Args[2:])
+		return
+	}
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: monogen [flags] FILE...")
+		fmt.Fprintln(flag.CommandLine.Output(), "       monogen compat [flags] OLD NEW")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	monolithAlias = *alias
 	set := token.NewFileSet()
 	var files []File
 	var out []string
-	for _, name := range os.Args[1:] {
-		f, _ := parser.ParseFile(set, name, nil, parser.ParseComments)
-		file := parseFile(f).filter()
+	for _, name := range flag.Args() {
+		f, err := parser.ParseFile(set, name, nil, parser.ParseComments)
+		if err != nil {
+			log.Fatal(err)
+		}
+		file, err := parseFile(f).filter()
+		if err != nil {
+			log.Fatal(err)
+		}
 		files = append(files, file)
-		ext := path.Ext(name)
-		base := strings.TrimSuffix(name, ext)
-		out = append(out, base+".g"+ext)
+		out = append(out, outputName(name))
 	}
-	sm := createServiceMap(files)
+	sm, err := createServiceMap(files)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stale := 0
 	for i := 0; i < len(files); i++ {
-		g := generateFile(files[i], sm)
-		f, err := os.Create(out[i])
+		var buffer bytes.Buffer
+		err = generateFile(files[i], sm).Render(&buffer)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = g.Render(f)
-		if err != nil {
+		if !*check && !*diff {
+			err = os.WriteFile(out[i], buffer.Bytes(), 0666)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *schema {
+				writeSchema(out[i], files[i], sm)
+			}
+			continue
+		}
+		current, err := os.ReadFile(out[i])
+		if err != nil && !os.IsNotExist(err) {
 			log.Fatal(err)
 		}
+		if bytes.Equal(current, buffer.Bytes()) {
+			continue
+		}
+		stale++
+		if *diff {
+			fmt.Print(unifiedDiff(out[i], string(current), buffer.String()))
+		} else {
+			fmt.Printf("%v is out of date\n", out[i])
+		}
+	}
+	if stale != 0 {
+		os.Exit(1)
+	}
+}
+
+func outputName(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if *outDir != "" {
+		base = filepath.Join(*outDir, filepath.Base(base))
+	}
+	return base + *suffix + ext
+}
+
+func writeSchema(out string, f File, sm ServiceMap) {
+	var apis []ServiceAPI
+	for _, i := range f.Interfaces {
+		apis = append(apis, interfaceAPI(i))
+	}
+	for _, t := range f.Types {
+		apis = append(apis, serviceAPI(sm[[2]string{f.Package.Name, t.Name}]))
+	}
+	data, err := json.MarshalIndent(apis, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	name := strings.TrimSuffix(out, filepath.Ext(out)) + ".schema.json"
+	err = os.WriteFile(name, append(data, '\n'), 0666)
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -1,20 +1,51 @@
 package main
 
 import (
+	"fmt"
 	"go/ast"
+	"go/parser"
 	"go/token"
+	"go/types"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-func valueGroupFromField(field *ast.Field) ValueGroup {
+func valueGroupFromField(field *ast.Field, imports Imports) ValueGroup {
 	var vg ValueGroup
-	vg.Type = field.Type.(*ast.Ident).Name
+	vg.Type = types.ExprString(field.Type)
+	vg.Expr = field.Type
+	vg.Imports = imports
 	for _, name := range field.Names {
 		vg.Names = append(vg.Names, name.Name)
 	}
 	return vg
 }
 
-func parseInterfaceMethod(field *ast.Field) Function {
+func parseImports(f *ast.File) Imports {
+	imports := make(Imports)
+	for _, spec := range f.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, "v")); err == nil && strings.HasPrefix(name, "v") {
+			name = path.Base(path.Dir(p))
+		}
+		if i := strings.Index(name, ".v"); i > 0 {
+			name = name[:i]
+		}
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = p
+	}
+	return imports
+}
+
+func parseInterfaceMethod(field *ast.Field, imports Imports) Function {
 	var f Function
 	f.Name = field.Names[0].Name
 	if doc := field.Doc; doc != nil {
@@ -23,11 +54,11 @@ func parseInterfaceMethod(field *ast.Field) Function {
 		}
 	}
 	funcType := field.Type.(*ast.FuncType)
-	f.Params, f.Results = parseFuncType(funcType)
+	f.Params, f.Results = parseFuncType(funcType, imports)
 	return f
 }
 
-func parseGenDecl(d *ast.GenDecl) (*Type, *Interface) {
+func parseGenDecl(d *ast.GenDecl, imports Imports) (*Type, *Interface) {
 	if d.Tok != token.TYPE {
 		return nil, nil
 	}
@@ -48,7 +79,7 @@ func parseGenDecl(d *ast.GenDecl) (*Type, *Interface) {
 	var methods []Function
 	if ims := interfaceType.Methods; ims != nil {
 		for _, m := range ims.List {
-			methods = append(methods, parseInterfaceMethod(m))
+			methods = append(methods, parseInterfaceMethod(m, imports))
 		}
 	}
 	return nil, &Interface{
@@ -60,15 +91,16 @@ func parseGenDecl(d *ast.GenDecl) (*Type, *Interface) {
 func parseFile(f *ast.File) File {
 	var file File
 	file.Name = f.Name.Name
+	file.Imports = parseImports(f)
 	for _, decl := range f.Decls {
 		switch decl.(type) {
 		case *ast.FuncDecl:
 			fd := decl.(*ast.FuncDecl)
-			method := parseFuncDecl(fd)
+			method := parseFuncDecl(fd, file.Imports)
 			file.Methods = append(file.Methods, method)
 		case *ast.GenDecl:
 			gd := decl.(*ast.GenDecl)
-			t, i := parseGenDecl(gd)
+			t, i := parseGenDecl(gd, file.Imports)
 			if t != nil {
 				file.Types = append(file.Types, *t)
 			}
@@ -80,15 +112,11 @@ func parseFile(f *ast.File) File {
 	return file
 }
 
-func parseFuncDecl(d *ast.FuncDecl) Method {
+func parseFuncDecl(d *ast.FuncDecl, imports Imports) Method {
 	var receiver *ValueGroup
 	if d.Recv != nil && len(d.Recv.List) > 0 {
-		var r ValueGroup
-		field := d.Recv.List[0]
-		r.Type = field.Type.(*ast.Ident).Name
-		for _, name := range field.Names {
-			r.Names = append(r.Names, name.Name)
-		}
+		r := valueGroupFromField(d.Recv.List[0], imports)
+		r.Type = strings.TrimPrefix(r.Type, "*")
 		receiver = &r
 	}
 	var f Function
@@ -98,44 +126,140 @@ func parseFuncDecl(d *ast.FuncDecl) Method {
 			f.Comments = append(f.Comments, comment.Text)
 		}
 	}
-	f.Params, f.Results = parseFuncType(d.Type)
+	f.Params, f.Results = parseFuncType(d.Type, imports)
 	return Method{
 		Receiver: receiver,
 		Function: f,
 	}
 }
 
-func parseFuncType(f *ast.FuncType) ([]ValueGroup, []ValueGroup) {
+func parseFuncType(f *ast.FuncType, imports Imports) ([]ValueGroup, []ValueGroup) {
 	var params, results []ValueGroup
 	for _, param := range f.Params.List {
-		vg := valueGroupFromField(param)
+		vg := valueGroupFromField(param, imports)
 		params = append(params, vg)
 	}
+	if f.Results == nil {
+		return params, results
+	}
 	for _, result := range f.Results.List {
-		vg := valueGroupFromField(result)
+		vg := valueGroupFromField(result, imports)
 		results = append(results, vg)
 	}
 	return params, results
 }
 
+func (d Decl) directive(name string) (Directive, bool) {
+	prefix := "//monolith:" + name
+	for _, comment := range d.Comments {
+		if comment != prefix && !strings.HasPrefix(comment, prefix+" ") {
+			continue
+		}
+		args := make(Directive)
+		for _, field := range strings.Fields(strings.TrimPrefix(comment, prefix)) {
+			key, value, _ := strings.Cut(field, "=")
+			args[key] = value
+		}
+		return args, true
+	}
+	return nil, false
+}
+
 func (d Decl) isIgnored() bool {
-	return find("//monolith:ignore", d.Comments) > -1
+	_, ok := d.directive("ignore")
+	return ok
 }
 
 func (d Decl) isService() bool {
-	return find("//monolith:service", d.Comments) > -1
+	_, ok := d.directive("service")
+	return ok
+}
+
+func (d Decl) isConstructor() bool {
+	_, ok := d.directive("constructor")
+	return ok
 }
 
-func (f File) filter() File {
+func (vg ValueGroup) isContext() bool {
+	selector, ok := vg.Expr.(*ast.SelectorExpr)
+	if !ok || selector.Sel.Name != "Context" {
+		return false
+	}
+	x, ok := selector.X.(*ast.Ident)
+	return ok && vg.Imports[x.Name] == "context"
+}
+
+func flatten(vgs []ValueGroup) []ValueGroup {
+	var result []ValueGroup
+	for _, vg := range vgs {
+		if len(vg.Names) == 0 {
+			result = append(result, vg)
+			continue
+		}
+		for _, name := range vg.Names {
+			single := vg
+			single.Names = []string{name}
+			result = append(result, single)
+		}
+	}
+	return result
+}
+
+func (i Interface) parseID(imports Imports) (*ValueGroup, error) {
+	args, _ := i.directive("service")
+	_, singleton := args["singleton"]
+	idType, hasID := args["id"]
+	switch {
+	case singleton && hasID:
+		return nil, fmt.Errorf("singleton service %v cannot have an instance ID", i.Name)
+	case singleton:
+		return nil, nil
+	case !hasID:
+		idType = "string"
+	}
+	expr, err := parser.ParseExpr(idType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance ID type of service %v: %w", i.Name, err)
+	}
+	return &ValueGroup{
+		Type:    types.ExprString(expr),
+		Expr:    expr,
+		Imports: imports,
+	}, nil
+}
+
+var versionPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+){0,2}$`)
+
+func (d Decl) version() (string, error) {
+	args, _ := d.directive("service")
+	version := args["version"]
+	if version != "" && !versionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q of service %v", version, d.Name)
+	}
+	return version, nil
+}
+
+func (f File) filter() (File, error) {
 	var ts []Type
 	for _, t := range f.Types {
 		if t.isService() && !t.isIgnored() {
+			if _, err := t.version(); err != nil {
+				return File{}, err
+			}
 			ts = append(ts, t)
 		}
 	}
 	var is []Interface
 	for _, i := range f.Interfaces {
 		if i.isService() && !i.isIgnored() {
+			id, err := i.parseID(f.Imports)
+			if err != nil {
+				return File{}, err
+			}
+			if _, err := i.version(); err != nil {
+				return File{}, err
+			}
+			i.ID = id
 			var ms []Function
 			for _, m := range i.Methods {
 				if !m.isIgnored() {
@@ -154,13 +278,76 @@ func (f File) filter() File {
 	}
 	return File{
 		Package:    f.Package,
+		Imports:    f.Imports,
 		Types:      ts,
 		Methods:    ms,
 		Interfaces: is,
+	}, nil
+}
+
+func isLegacyConstructor(m Method) bool {
+	params := flatten(m.Params)
+	results := flatten(m.Results)
+	return m.Receiver == nil &&
+		len(params) == 1 &&
+		params[0].Type == "string" &&
+		len(results) == 2 &&
+		results[1].Type == "error"
+}
+
+func newConstructor(m Method, legacy bool) (*Constructor, error) {
+	results := flatten(m.Results)
+	if m.Receiver != nil || len(results) != 2 || results[1].Type != "error" {
+		return nil, fmt.Errorf("constructor %v must be a function returning a service and an error", m.Name)
+	}
+	args, _ := m.directive("constructor")
+	_, singleton := args["singleton"]
+	if singleton && args["id"] != "" {
+		return nil, fmt.Errorf("singleton constructor %v cannot have an instance ID parameter", m.Name)
+	}
+	idName := args["id"]
+	if idName == "" {
+		idName = "id"
+	}
+	c := Constructor{
+		Method: m,
+	}
+	for _, p := range flatten(m.Params) {
+		var name string
+		if len(p.Names) != 0 {
+			name = p.Names[0]
+		}
+		a := Argument{
+			Kind:       DependencyArgument,
+			Name:       name,
+			ValueGroup: p,
+		}
+		switch {
+		case p.isContext():
+			a.Kind = ContextArgument
+		case !singleton && c.instanceID() == nil && (legacy || name == idName):
+			a.Kind = IDArgument
+		}
+		c.Arguments = append(c.Arguments, a)
+	}
+	if !singleton && c.instanceID() == nil {
+		// A renamed parameter must not silently turn the service into a singleton.
+		return nil, fmt.Errorf("constructor %v has no instance ID parameter %v, "+
+			"rename it or mark a singleton with //monolith:constructor singleton", m.Name, idName)
+	}
+	return &c, nil
+}
+
+func (c Constructor) instanceID() *Argument {
+	for _, a := range c.Arguments {
+		if a.Kind == IDArgument {
+			return &a
+		}
 	}
+	return nil
 }
 
-func createServiceMap(fs []File) ServiceMap {
+func createServiceMap(fs []File) (ServiceMap, error) {
 	services := make(map[[2]string]*Service)
 	for _, f := range fs {
 		for _, t := range f.Types {
@@ -169,6 +356,8 @@ func createServiceMap(fs []File) ServiceMap {
 			}
 		}
 	}
+	var legacy [][2]string
+	var candidates []Method
 	for _, f := range fs {
 		for _, m := range f.Methods {
 			r := m.Receiver
@@ -177,23 +366,51 @@ func createServiceMap(fs []File) ServiceMap {
 				if ok {
 					service.Methods = append(service.Methods, m)
 				}
+				continue
 			}
-			isConstructor := r == nil &&
-				len(m.Params) == 1 &&
-				len(m.Params[0].Names) == 1 &&
-				m.Params[0].Type == "string" &&
-				len(m.Results) == 2 &&
-				len(m.Results[0].Names) == 1 &&
-				len(m.Results[1].Names) == 1 &&
-				m.Results[1].Type == "error"
-			if isConstructor {
-				service, ok := services[[2]string{f.Package.Name, m.Results[0].Type}]
-				if !ok || service.Constructor != nil {
-					continue
+			results := flatten(m.Results)
+			if len(results) == 0 {
+				continue
+			}
+			key := [2]string{f.Package.Name, strings.TrimPrefix(results[0].Type, "*")}
+			if !m.isConstructor() {
+				if isLegacyConstructor(m) {
+					legacy = append(legacy, key)
+					candidates = append(candidates, m)
 				}
-				service.Constructor = &m
+				continue
+			}
+			service, ok := services[key]
+			if !ok {
+				return nil, fmt.Errorf("constructor %v does not return a service type", m.Name)
 			}
+			if service.Constructor != nil {
+				return nil, fmt.Errorf("service %v has several constructors: %v and %v",
+					service.Type.Name, service.Constructor.Name, m.Name)
+			}
+			constructor, err := newConstructor(m, false)
+			if err != nil {
+				return nil, err
+			}
+			service.Constructor = constructor
+		}
+	}
+	for i, key := range legacy {
+		service, ok := services[key]
+		if !ok || service.Constructor != nil {
+			continue
+		}
+		constructor, err := newConstructor(candidates[i], true)
+		if err != nil {
+			return nil, err
+		}
+		service.Constructor = constructor
+	}
+	for _, service := range services {
+		if service.Constructor == nil {
+			return nil, fmt.Errorf("service %v has no constructor, mark one with //monolith:constructor",
+				service.Type.Name)
 		}
 	}
-	return services
+	return services, nil
 }
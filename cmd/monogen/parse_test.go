@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConstructorInstanceID(t *testing.T) {
+	tests := []struct {
+		name        string
+		constructor string
+		id          string
+		err         string
+	}{
+		{
+			name:        "id parameter",
+			constructor: "//monolith:constructor\nfunc NewMath(id int) (Math, error)",
+			id:          "int",
+		},
+		{
+			name:        "named id parameter",
+			constructor: "//monolith:constructor id=key\nfunc NewMath(ctx context.Context, key int64) (Math, error)",
+			id:          "int64",
+		},
+		{
+			name:        "legacy constructor",
+			constructor: "func NewMath(name string) (Math, error)",
+			id:          "string",
+		},
+		{
+			name:        "singleton",
+			constructor: "//monolith:constructor singleton\nfunc NewMath(ctx context.Context) (Math, error)",
+		},
+		{
+			name:        "renamed id parameter",
+			constructor: "//monolith:constructor\nfunc NewMath(key int) (Math, error)",
+			err:         "no instance ID parameter id",
+		},
+		{
+			name:        "missing named id parameter",
+			constructor: "//monolith:constructor id=key\nfunc NewMath(id int) (Math, error)",
+			err:         "no instance ID parameter key",
+		},
+		{
+			name:        "singleton with id parameter",
+			constructor: "//monolith:constructor singleton id=key\nfunc NewMath(key int) (Math, error)",
+			err:         "cannot have an instance ID",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := "package math\n\nimport \"context\"\n\nvar _ context.Context\n\n" +
+				"//monolith:service\ntype Math struct{}\n\nfunc (m Math) Add(a, b int) int { return a + b }\n\n" +
+				test.constructor + " { return Math{}, nil }\n"
+			api, err := parseSources(map[string][]byte{"math.go": []byte(source)})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want an error with %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id := api["Math"].ID; id != test.id {
+				t.Fatalf("got instance ID %q, want %q", id, test.id)
+			}
+		})
+	}
+}
@@ -21,7 +21,7 @@ func runClient() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	math, err := monolith.Get[Math]("1", &client)
+	math, err := GetMath(1, &client)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -8,6 +8,57 @@ func init() {
 	m.RegisterProxy[Math](func(i m.Instance) any {
 		return MathProxy(i)
 	})
+	m.RegisterProxySchema[Math](m.Schema{
+		Hash: "6e45995a089aa1aaf3e76ca106a54c1a",
+		ID:   "int",
+		Methods: []m.MethodSchema{{
+			Name: "Add",
+			Params: []m.ValueSchema{{
+				Name: "A",
+				Type: "int",
+			}, {
+				Name: "B",
+				Type: "int",
+			}},
+			Results: []m.ValueSchema{{
+				Name: "C",
+				Type: "int",
+			}, {
+				Name: "Err",
+				Type: "error",
+			}},
+		}, {
+			Name: "Divide",
+			Params: []m.ValueSchema{{
+				Name: "A",
+				Type: "int",
+			}, {
+				Name: "B",
+				Type: "int",
+			}},
+			Results: []m.ValueSchema{{
+				Name: "R",
+				Type: "int",
+			}, {
+				Name: "R2",
+				Type: "error",
+			}},
+		}, {
+			Name: "Sqrt",
+			Params: []m.ValueSchema{{
+				Name: "X",
+				Type: "float64",
+			}},
+			Results: []m.ValueSchema{{
+				Name: "R",
+				Type: "float64",
+			}},
+		}},
+		Service: "Math",
+	})
+}
+func GetMath(id int, client *m.Client, options ...m.GetOption) (Math, error) {
+	return m.Get[Math](id, client, options...)
 }
 func (p MathProxy) Add(a, b int) (c int, err error) {
 	params := struct {
@@ -2,7 +2,7 @@ package main
 
 //go:generate monogen math.go
 
-//monolith:service
+//monolith:service id=int
 type Math interface {
 	Add(a, b int) (c int, err error)
 	Divide(a int, b int) (int, error)
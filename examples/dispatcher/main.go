@@ -12,6 +12,7 @@ const (
 	name             = "default"
 	announceEndPoint = "127.0.0.1:3001"
 	requestEndPoint  = "127.0.0.1:3002"
+	adminEndPoint    = "127.0.0.1:3003"
 )
 
 func main() {
@@ -29,6 +30,10 @@ func runDispatcher() *monolith.Dispatcher {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = d.ListenAdmin(adminEndPoint)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return &d
 }
 
@@ -21,11 +21,11 @@ func main() {
 
 func runServer() *monolith.Server {
 	s := monolith.NewServer(name)
-	err := s.AnnounceServices(serverEndPoint, dispatcherAnnounceEndPoint)
+	err := s.Serve(serverEndPoint)
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = s.Serve(serverEndPoint)
+	err = s.AnnounceServices(dispatcherAnnounceEndPoint)
 	if err != nil {
 		log.Fatal(err)
 	}
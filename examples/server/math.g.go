@@ -1,12 +1,69 @@
 package main
 
-import m "github.com/orangootan/monolith/pkg/monolith"
+import (
+	"context"
+	m "github.com/orangootan/monolith/pkg/monolith"
+)
 
 func init() {
 	m.RegisterTypeHandler("Math", MathHandler)
+	if err := m.RegisterTypeSchema(m.Schema{
+		Hash: "6e45995a089aa1aaf3e76ca106a54c1a",
+		ID:   "int",
+		Methods: []m.MethodSchema{{
+			Name: "Add",
+			Params: []m.ValueSchema{{
+				Name: "A",
+				Type: "int",
+			}, {
+				Name: "B",
+				Type: "int",
+			}},
+			Results: []m.ValueSchema{{
+				Name: "C",
+				Type: "int",
+			}, {
+				Name: "Err",
+				Type: "error",
+			}},
+		}, {
+			Name: "Divide",
+			Params: []m.ValueSchema{{
+				Name: "A",
+				Type: "int",
+			}, {
+				Name: "B",
+				Type: "int",
+			}},
+			Results: []m.ValueSchema{{
+				Name: "R",
+				Type: "int",
+			}, {
+				Name: "R2",
+				Type: "error",
+			}},
+		}, {
+			Name: "Sqrt",
+			Params: []m.ValueSchema{{
+				Name: "X",
+				Type: "float64",
+			}},
+			Results: []m.ValueSchema{{
+				Name: "R",
+				Type: "float64",
+			}},
+		}},
+		Service: "Math",
+	}); err != nil {
+		panic(err)
+	}
 }
-func MathHandler(id string, method string, decode func(params any) error, encode func(params any) error) (err error) {
-	instance, err := MathFromString(id)
+func MathHandler(ctx context.Context, rawID []byte, method string, decode func(params any) error, encode func(params any) error) (err error) {
+	id, err := m.DecodeID[int](rawID)
+	if err != nil {
+		return
+	}
+	instance, err := NewMath(id)
 	if err != nil {
 		return
 	}
@@ -5,7 +5,6 @@ package main
 import (
 	"github.com/orangootan/monolith/pkg/monolith"
 	"math"
-	"strconv"
 )
 
 //monolith:service
@@ -29,10 +28,8 @@ func (m Math) Sqrt(x float64) float64 {
 	return math.Sqrt(x)
 }
 
-func MathFromString(id string) (math Math, err error) {
-	math.c, err = strconv.Atoi(id)
-	if err != nil {
-		err = monolith.NewError(err.Error())
-	}
+//monolith:constructor
+func NewMath(id int) (math Math, err error) {
+	math.c = id
 	return
 }
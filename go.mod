@@ -6,4 +6,5 @@ require (
 	github.com/dave/jennifer v1.6.0
 	github.com/google/uuid v1.3.0
 	golang.org/x/text v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,401 @@
+package monolith
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	adminEndPoints   = "endpoints"
+	adminAdd         = "add"
+	adminRemove      = "remove"
+	adminDrain       = "drain"
+	adminUndrain     = "undrain"
+	adminConnections = "connections"
+)
+
+type EndPointInfo struct {
+	Namespace string            `json:"namespace"`
+	Service   string            `json:"service"`
+	Version   string            `json:"version"`
+	Address   string            `json:"address"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Verified  bool              `json:"verified"`
+	Healthy   bool              `json:"healthy"`
+	Draining  bool              `json:"draining"`
+	LastSeen  time.Time         `json:"lastSeen"`
+}
+
+type ConnectionInfo struct {
+	Kind     string    `json:"kind"`
+	Local    string    `json:"local"`
+	Remote   string    `json:"remote"`
+	Since    time.Time `json:"since"`
+	Watching []string  `json:"watching,omitempty"`
+}
+
+type adminRequest struct {
+	Command   string
+	EndPoint  EndPointInfo
+	Identity  string
+	Timestamp int64
+	Token     string
+}
+
+type adminResponse struct {
+	EndPoints   []EndPointInfo
+	Connections []ConnectionInfo
+	Changed     int
+	Err         error
+}
+
+func (d *Dispatcher) ListenAdmin(endPoint string) (err error) {
+	local, err := net.ResolveTCPAddr("tcp", endPoint)
+	if err != nil {
+		return
+	}
+	if d.announceAuth == nil && !local.IP.IsLoopback() {
+		return InsecureAdminError
+	}
+	listener, err := net.ListenTCP("tcp", local)
+	if err != nil {
+		return
+	}
+	d.logger.info("started listening admin connections", "endpoint", endPoint)
+	d.listeners = append(d.listeners, listener)
+	var wg sync.WaitGroup
+	d.wgs = append(d.wgs, &wg)
+	go func() {
+		defer d.logger.info("stopped listening admin connections", "endpoint", endPoint)
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					d.logger.error("failed to accept connection", "error", err)
+				}
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var c net.Conn = conn
+				if d.announceTLS != nil {
+					c = tls.Server(conn, d.announceTLS)
+				}
+				err := d.administer(c)
+				if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					d.logger.warn("admin connection failed", "peer", conn.RemoteAddr(), "error", err)
+				}
+			}()
+		}
+	}()
+	return
+}
+
+func (d *Dispatcher) administer(conn net.Conn) (err error) {
+	defer d.track(conn, "admin")()
+	defer func() {
+		closeErr := conn.Close()
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			if err == nil {
+				err = closeErr
+			} else {
+				d.logger.warn("failed to close connection", "error", closeErr)
+			}
+		}
+	}()
+	remote := conn.RemoteAddr().String()
+	tlsIdentity, err := peerIdentity(conn)
+	if err != nil {
+		return
+	}
+	decoder := newFrameDecoder(conn, d.frames)
+	encoder := newFrameEncoder(conn, d.frames)
+	for {
+		var req adminRequest
+		err = decoder.Decode(&req)
+		if err != nil {
+			if errors.Is(err, FrameTooLargeError) {
+				_ = encoder.Encode(adminResponse{
+					Err: err,
+				})
+			}
+			return
+		}
+		identity, authErr := d.authenticate(UnauthenticatedAdminError, req.Identity, req.Timestamp, req.Token, tlsIdentity,
+			func(secret []byte) string {
+				return adminToken(secret, req)
+			})
+		var res adminResponse
+		if authErr != nil {
+			res.Err = authErr
+		} else {
+			res = d.admin(req, identity)
+		}
+		if res.Err != nil {
+			res.Err = wireError(res.Err)
+		}
+		if req.Command != adminEndPoints && req.Command != adminConnections || authErr != nil {
+			d.logger.info("admin command", "peer", remote, "identity", identity, "command", req.Command,
+				"endpoint", req.EndPoint.Address, "changed", res.Changed, "error", res.Err)
+		}
+		err = encoder.Encode(res)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) admin(req adminRequest, identity string) (res adminResponse) {
+	e := req.EndPoint
+	permit := func(name string) error {
+		if d.announceAuth == nil {
+			return nil
+		}
+		return d.permit(UnauthorizedAdminError, identity, name)
+	}
+	switch req.Command {
+	case adminEndPoints:
+		res.EndPoints = d.endPointInfos(e.Namespace)
+	case adminConnections:
+		res.Connections = d.connectionInfos()
+	case adminAdd:
+		if e.Service == "" || e.Address == "" {
+			res.Err = MissingEndPointError
+			return
+		}
+		if !validNamespace(e.Namespace) {
+			res.Err = InvalidNamespaceError.withDetail("'%v'", e.Namespace)
+			return
+		}
+		version, err := canonicalVersion(e.Version)
+		if err != nil {
+			res.Err = err
+			return
+		}
+		name := qualify(e.Namespace, e.Service)
+		res.Err = permit(name)
+		if res.Err != nil {
+			return
+		}
+		res.Err = d.apply(registryOp{
+			Kind:    putOp,
+			Service: name,
+			Registration: registration{
+				Version:  version,
+				EndPoint: e.Address,
+				Labels:   e.Labels,
+				Verified: true,
+				Draining: e.Draining,
+			},
+		})
+		if res.Err == nil {
+			res.Changed = 1
+		}
+	case adminRemove, adminDrain, adminUndrain:
+		if e.Address == "" {
+			res.Err = MissingEndPointError
+			return
+		}
+		ops, err := d.matching(e)
+		if err != nil {
+			res.Err = err
+			return
+		}
+		// Nothing changes unless the identity may administer every matching service.
+		for _, op := range ops {
+			res.Err = permit(op.Service)
+			if res.Err != nil {
+				return
+			}
+		}
+		for _, op := range ops {
+			switch req.Command {
+			case adminRemove:
+				op.Kind = removeOp
+			case adminDrain:
+				op.Registration.Draining = true
+			case adminUndrain:
+				op.Registration.Draining = false
+			}
+			res.Err = d.apply(op)
+			if res.Err != nil {
+				return
+			}
+			res.Changed++
+		}
+	default:
+		res.Err = UnknownCommandError.withDetail("'%v'", req.Command)
+	}
+	return
+}
+
+func (d *Dispatcher) matching(e EndPointInfo) (ops []registryOp, err error) {
+	version := ""
+	if e.Version != "" {
+		version, err = canonicalVersion(e.Version)
+		if err != nil {
+			return
+		}
+	}
+	for _, op := range d.services.ops() {
+		namespace, service := splitQualified(op.Service)
+		switch {
+		case op.Registration.EndPoint != e.Address:
+		case e.Namespace != "" && e.Namespace != namespace:
+		case e.Service != "" && e.Service != service:
+		case e.Version != "" && version != op.Registration.Version:
+		default:
+			ops = append(ops, op)
+		}
+	}
+	return
+}
+
+func (d *Dispatcher) endPointInfos(namespace string) []EndPointInfo {
+	var infos []EndPointInfo
+	for _, op := range d.services.ops() {
+		ns, service := splitQualified(op.Service)
+		if namespace != "" && namespace != ns {
+			continue
+		}
+		reg := op.Registration
+		healthy, lastSeen := d.services.status(reg.EndPoint)
+		infos = append(infos, EndPointInfo{
+			Namespace: ns,
+			Service:   service,
+			Version:   reg.Version,
+			Address:   reg.EndPoint,
+			Labels:    reg.Labels,
+			Verified:  reg.Verified,
+			Healthy:   healthy,
+			Draining:  reg.Draining,
+			LastSeen:  lastSeen,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Address < b.Address
+	})
+	return infos
+}
+
+func (d *Dispatcher) connectionInfos() []ConnectionInfo {
+	infos := connectionInfos(d.connections)
+	for i, info := range infos {
+		if w, ok := d.watchers.get(info.Local + "-" + info.Remote); ok {
+			infos[i].Watching = w.subscriptions()
+		}
+	}
+	return infos
+}
+
+type AdminClient struct {
+	conn     net.Conn
+	encoder  *frameEncoder
+	decoder  *frameDecoder
+	identity string
+	secret   []byte
+}
+
+func DialAdmin(endPoint string) (a *AdminClient, err error) {
+	conn, err := net.Dial("tcp", endPoint)
+	if err != nil {
+		return
+	}
+	return newAdminClient(conn), nil
+}
+
+func DialAdminTLS(endPoint string, config *tls.Config) (a *AdminClient, err error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{
+		Timeout: tlsHandshakeTimeout,
+	}, "tcp", endPoint, config)
+	if err != nil {
+		return
+	}
+	return newAdminClient(conn), nil
+}
+
+func newAdminClient(conn net.Conn) *AdminClient {
+	return &AdminClient{
+		conn:    conn,
+		encoder: newFrameEncoder(conn, DefaultFrameLimits),
+		decoder: newFrameDecoder(conn, DefaultFrameLimits),
+	}
+}
+
+func (a *AdminClient) SetCredentials(identity string, secret []byte) {
+	a.identity = identity
+	a.secret = secret
+}
+
+func (a *AdminClient) Close() error {
+	return a.conn.Close()
+}
+
+func (a *AdminClient) call(command string, e EndPointInfo) (res adminResponse, err error) {
+	req := adminRequest{
+		Command:  command,
+		EndPoint: e,
+		Identity: a.identity,
+	}
+	if a.secret != nil {
+		req.Timestamp = time.Now().Unix()
+		req.Token = adminToken(a.secret, req)
+	}
+	err = a.encoder.Encode(req)
+	if err != nil {
+		return
+	}
+	err = a.decoder.Decode(&res)
+	if err != nil {
+		return
+	}
+	err = res.Err
+	return
+}
+
+func (a *AdminClient) EndPoints(namespace string) ([]EndPointInfo, error) {
+	res, err := a.call(adminEndPoints, EndPointInfo{
+		Namespace: namespace,
+	})
+	return res.EndPoints, err
+}
+
+func (a *AdminClient) Connections() ([]ConnectionInfo, error) {
+	res, err := a.call(adminConnections, EndPointInfo{})
+	return res.Connections, err
+}
+
+func (a *AdminClient) Add(e EndPointInfo) error {
+	_, err := a.call(adminAdd, e)
+	return err
+}
+
+func (a *AdminClient) Remove(e EndPointInfo) (int, error) {
+	res, err := a.call(adminRemove, e)
+	return res.Changed, err
+}
+
+func (a *AdminClient) Drain(e EndPointInfo, draining bool) (int, error) {
+	command := adminDrain
+	if !draining {
+		command = adminUndrain
+	}
+	res, err := a.call(command, e)
+	return res.Changed, err
+}
@@ -0,0 +1,150 @@
+package monolith
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	minAnnounceBackoff = 100 * time.Millisecond
+	maxAnnounceBackoff = 10 * time.Second
+)
+
+func (s *Server) AnnounceServices(announceEndPoints ...string) (err error) {
+	if len(announceEndPoints) == 0 {
+		return NoDispatcherError
+	}
+	address := s.AdvertisedAddress()
+	if address == "" {
+		return NoAdvertisedAddressError
+	}
+	dispatchers := newEndPointPool(announceEndPoints)
+	dispatchers.setOrder(s.dispatcherOrder)
+	conn, announceEndPoint, err := s.announceAny(dispatchers, address)
+	if err != nil {
+		return
+	}
+	go s.keepAnnounced(dispatchers, announceEndPoint, address, conn)
+	return
+}
+
+func (s *Server) SetDispatcherOrder(order EndPointOrder) {
+	s.dispatcherOrder = order
+}
+
+func (s *Server) announceAny(dispatchers *endPointPool, address string) (conn net.Conn, announceEndPoint string, err error) {
+	err = dispatchers.try(func(endPoint string) (err error) {
+		conn, err = s.announce(endPoint, address)
+		if err != nil {
+			s.logger.warn("dispatcher failed", "dispatcher", endPoint, "error", err)
+			return
+		}
+		announceEndPoint = endPoint
+		return
+	})
+	return
+}
+
+func (s *Server) announce(announceEndPoint, address string) (conn net.Conn, err error) {
+	if s.announceTLS != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{
+			Timeout: tlsHandshakeTimeout,
+		}, "tcp", announceEndPoint, s.announceTLS)
+	} else {
+		conn, err = net.Dial("tcp", announceEndPoint)
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			closeErr := conn.Close()
+			if closeErr != nil {
+				s.logger.warn("failed to close connection", "error", closeErr)
+			}
+		}
+	}()
+	s.logger.info("connected to dispatcher", "dispatcher", announceEndPoint)
+	encoder := newFrameEncoder(conn, s.frames)
+	labels := s.Labels()
+	for key := range typeHandlers {
+		err = encoder.Encode(s.sign(announcement{
+			Namespace: s.namespace,
+			Service:   key.Name,
+			Version:   key.Version,
+			Address:   address,
+			Labels:    labels,
+			Schema:    typeSchemas[key],
+		}))
+		if err != nil {
+			return
+		}
+	}
+	decoder := newFrameDecoder(conn, s.frames)
+	var rejected error
+	accepted := 0
+	for range typeHandlers {
+		var ack announceAck
+		err = decoder.Decode(&ack)
+		if err != nil {
+			return
+		}
+		if ack.Err != nil {
+			s.logger.error("dispatcher rejected service", "dispatcher", announceEndPoint,
+				"service", ack.Service, "version", ack.Version, "error", ack.Err)
+			rejected = ack.Err
+			continue
+		}
+		accepted++
+		s.logger.info("announced service", "dispatcher", announceEndPoint,
+			"service", ack.Service, "version", ack.Version, "endpoint", address)
+	}
+	// Services the dispatcher accepted stay announced even if others were refused.
+	if accepted == 0 {
+		err = rejected
+	}
+	return
+}
+
+func (s *Server) keepAnnounced(dispatchers *endPointPool, announceEndPoint, address string, conn net.Conn) {
+	for {
+		s.waitClosed(conn)
+		if s.done.Err() != nil {
+			return
+		}
+		s.logger.warn("lost connection to dispatcher", "dispatcher", announceEndPoint)
+		dispatchers.markFailed(announceEndPoint)
+		backoff := minAnnounceBackoff
+		for {
+			var err error
+			conn, announceEndPoint, err = s.announceAny(dispatchers, address)
+			if err == nil {
+				break
+			}
+			select {
+			case <-s.done.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxAnnounceBackoff {
+				backoff = maxAnnounceBackoff
+			}
+		}
+	}
+}
+
+func (s *Server) waitClosed(conn net.Conn) {
+	closed := make(chan struct{})
+	go func() {
+		select {
+		case <-s.done.Done():
+		case <-closed:
+		}
+		_ = conn.Close()
+	}()
+	_, _ = io.Copy(io.Discard, conn)
+	close(closed)
+}
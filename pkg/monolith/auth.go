@@ -0,0 +1,274 @@
+package monolith
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MaxAnnounceClockSkew = 5 * time.Minute
+	tlsHandshakeTimeout  = 10 * time.Second
+	maxReplayEntries     = 1 << 20
+)
+
+type AnnounceAuth struct {
+	Secrets map[string][]byte
+	Policy  map[string][]string
+}
+
+type announceAck struct {
+	Service string
+	Version string
+	Err     error
+}
+
+func (d *Dispatcher) SetAnnounceAuth(auth AnnounceAuth) {
+	d.announceAuth = &auth
+}
+
+func (d *Dispatcher) SetAnnounceTLS(config *tls.Config) {
+	d.announceTLS = config
+}
+
+func (s *Server) SetAnnounceCredentials(identity string, secret []byte) {
+	s.identity = identity
+	s.secret = secret
+}
+
+func (s *Server) SetAnnounceTLS(config *tls.Config) {
+	s.announceTLS = config
+}
+
+func announceToken(secret []byte, a announcement) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, field := range []string{a.Identity, a.Namespace, a.Service, a.Version, a.Address, strconv.FormatInt(a.Timestamp, 10)} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	// Labels carry the weight, so they are signed with the schema; JSON sorts map keys.
+	for _, v := range []any{a.Labels, a.Schema} {
+		data, _ := json.Marshal(v)
+		mac.Write(data)
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func adminToken(secret []byte, req adminRequest) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, field := range []string{req.Identity, req.Command, strconv.FormatInt(req.Timestamp, 10)} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	data, _ := json.Marshal(req.EndPoint)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func callerToken(secret []byte, req request) string {
+	mac := hmac.New(sha256.New, secret)
+	params := sha256.Sum256(req.Params)
+	deadline := ""
+	if !req.Deadline.IsZero() {
+		deadline = strconv.FormatInt(req.Deadline.UnixNano(), 10)
+	}
+	for _, field := range []string{req.Caller, req.ID, req.Instance.Type, req.Instance.Version, hex.EncodeToString(req.Instance.ID),
+		req.Method, hex.EncodeToString(params[:]), deadline, strconv.FormatInt(req.Timestamp, 10)} {
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+	}
+	data, _ := json.Marshal(req.Metadata)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Client) SetCallerSecret(secret []byte) {
+	c.secret = secret
+}
+
+func (s *Server) SetCallerSecrets(secrets map[string][]byte) {
+	s.callerSecrets = secrets
+}
+
+func (c *Client) sign(req request) request {
+	// A gateway relays requests its callers already signed.
+	if c.secret == nil || req.Token != "" {
+		return req
+	}
+	req.Timestamp = time.Now().Unix()
+	req.Token = callerToken(c.secret, req)
+	return req
+}
+
+func (s *Server) authenticateCaller(req request) error {
+	if s.callerSecrets == nil {
+		return nil
+	}
+	if req.Token == "" {
+		return UnauthenticatedCallerError.withDetail("no credentials for caller %q", req.Caller)
+	}
+	err := verifyToken(s.callerSecrets, UnauthenticatedCallerError, req.Caller, req.Timestamp, req.Token,
+		func(secret []byte) string {
+			return callerToken(secret, req)
+		})
+	if err != nil {
+		return err
+	}
+	// A captured request stays valid until its timestamp expires, so its ID
+	// is remembered that long.
+	expires := time.Unix(req.Timestamp, 0).Add(MaxAnnounceClockSkew)
+	return s.replays.check(req.Caller, req.ID, expires, time.Now())
+}
+
+type replayKey struct {
+	caller string
+	id     string
+}
+
+type replayCache struct {
+	seen  map[replayKey]time.Time
+	swept time.Time
+	lock  sync.Mutex
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{
+		seen: make(map[replayKey]time.Time),
+	}
+}
+
+func (r *replayCache) check(caller, id string, expires, now time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := replayKey{caller, id}
+	if until, ok := r.seen[key]; ok && now.Before(until) {
+		return UnauthenticatedCallerError.withDetail("request %q from caller %q was already received", id, caller)
+	}
+	if now.Sub(r.swept) > MaxAnnounceClockSkew || len(r.seen) >= maxReplayEntries {
+		for k, until := range r.seen {
+			if !now.Before(until) {
+				delete(r.seen, k)
+			}
+		}
+		r.swept = now
+	}
+	// Forgetting IDs early would let them be replayed, so a full cache refuses instead.
+	if len(r.seen) >= maxReplayEntries {
+		return ResourceExhaustedError
+	}
+	r.seen[key] = expires
+	return nil
+}
+
+func (s *Server) sign(a announcement) announcement {
+	if s.identity == "" {
+		return a
+	}
+	a.Identity = s.identity
+	a.Timestamp = time.Now().Unix()
+	if s.secret != nil {
+		a.Token = announceToken(s.secret, a)
+	}
+	return a
+}
+
+func peerIdentity(conn net.Conn) (identity string, err error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return
+	}
+	certificate := certificates[0]
+	switch {
+	case certificate.Subject.CommonName != "":
+		identity = certificate.Subject.CommonName
+	case len(certificate.URIs) != 0:
+		identity = certificate.URIs[0].String()
+	case len(certificate.DNSNames) != 0:
+		identity = certificate.DNSNames[0]
+	}
+	return
+}
+
+func (d *Dispatcher) authorize(a announcement, tlsIdentity string) (identity string, err error) {
+	identity, err = d.authenticate(UnauthenticatedAnnounceError, a.Identity, a.Timestamp, a.Token, tlsIdentity,
+		func(secret []byte) string {
+			return announceToken(secret, a)
+		})
+	if err != nil || d.announceAuth == nil {
+		return
+	}
+	err = d.permit(UnauthorizedAnnounceError, identity, qualify(a.Namespace, a.Service))
+	return
+}
+
+func (d *Dispatcher) authenticate(base Error, claimed string, timestamp int64, token, tlsIdentity string,
+	sign func(secret []byte) string) (identity string, err error) {
+	identity = claimed
+	if tlsIdentity != "" {
+		identity = tlsIdentity
+	}
+	auth := d.announceAuth
+	if auth == nil {
+		return
+	}
+	switch {
+	case tlsIdentity != "":
+		if claimed != "" && claimed != tlsIdentity {
+			err = base.withDetail("identity %q does not match certificate identity %q", claimed, tlsIdentity)
+		}
+	case token != "":
+		err = verifyToken(auth.Secrets, base, claimed, timestamp, token, sign)
+	default:
+		err = base.withDetail("no credentials")
+	}
+	return
+}
+
+func verifyToken(secrets map[string][]byte, base Error, claimed string, timestamp int64, token string,
+	sign func(secret []byte) string) error {
+	secret, ok := secrets[claimed]
+	if !ok {
+		return base.withDetail("unknown identity %q", claimed)
+	}
+	if !hmac.Equal([]byte(token), []byte(sign(secret))) {
+		return base.withDetail("invalid token for identity %q", claimed)
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > MaxAnnounceClockSkew || skew < -MaxAnnounceClockSkew {
+		return base.withDetail("token for identity %q has expired", claimed)
+	}
+	return nil
+}
+
+func (d *Dispatcher) permit(base Error, identity, name string) error {
+	for _, pattern := range d.announceAuth.Policy[identity] {
+		// Patterns without a namespace only cover the default namespace.
+		if !strings.Contains(pattern, "/") {
+			pattern = qualify(DefaultNamespace, pattern)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return nil
+		}
+	}
+	return base.withDetail("identity %q, service '%v'", identity, name)
+}
@@ -0,0 +1,325 @@
+package monolith
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeAnnouncement(t *testing.T) {
+	secret := []byte("secret")
+	auth := AnnounceAuth{
+		Secrets: map[string][]byte{"billing": secret},
+		Policy: map[string][]string{
+			"billing":  {"Invoice*", "payments/*"},
+			"frontend": {"Web"},
+		},
+	}
+	signed := func(change func(a *announcement)) announcement {
+		s := NewServer("s")
+		s.SetAnnounceCredentials("billing", secret)
+		a := s.sign(announcement{
+			Service: "Invoices",
+			Version: "1.0.0",
+			Address: "127.0.0.1:4000",
+			Labels:  map[string]string{WeightLabel: "1", "zone": "a"},
+			Schema:  Schema{Service: "Invoices", Hash: "abc"},
+		})
+		if change != nil {
+			change(&a)
+		}
+		return a
+	}
+	resigned := func(change func(a *announcement)) announcement {
+		a := signed(change)
+		a.Token = announceToken(secret, a)
+		return a
+	}
+	tests := []struct {
+		name        string
+		announce    announcement
+		tlsIdentity string
+		identity    string
+		err         error
+		message     string
+	}{
+		{
+			name:     "valid token",
+			announce: signed(nil),
+			identity: "billing",
+		},
+		{
+			name:     "namespaced pattern",
+			announce: resigned(func(a *announcement) { a.Namespace, a.Service = "payments", "Refunds" }),
+			identity: "billing",
+		},
+		{
+			name:     "tampered address",
+			announce: signed(func(a *announcement) { a.Address = "10.0.0.1:4000" }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "invalid token",
+		},
+		{
+			name:     "tampered weight",
+			announce: signed(func(a *announcement) { a.Labels = map[string]string{WeightLabel: "100", "zone": "a"} }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "invalid token",
+		},
+		{
+			name:     "tampered schema",
+			announce: signed(func(a *announcement) { a.Schema.Hash = "def" }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "invalid token",
+		},
+		{
+			name:     "wrong secret",
+			announce: signed(func(a *announcement) { a.Token = announceToken([]byte("other"), *a) }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "invalid token",
+		},
+		{
+			name:     "unknown identity",
+			announce: signed(func(a *announcement) { a.Identity = "intruder" }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "unknown identity",
+		},
+		{
+			name:     "expired token",
+			announce: resigned(func(a *announcement) { a.Timestamp = time.Now().Add(-2 * MaxAnnounceClockSkew).Unix() }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "expired",
+		},
+		{
+			name:     "token from the future",
+			announce: resigned(func(a *announcement) { a.Timestamp = time.Now().Add(2 * MaxAnnounceClockSkew).Unix() }),
+			err:      UnauthenticatedAnnounceError,
+			message:  "expired",
+		},
+		{
+			name:     "no credentials",
+			announce: announcement{Service: "Invoices"},
+			err:      UnauthenticatedAnnounceError,
+			message:  "no credentials",
+		},
+		{
+			name:     "service outside policy",
+			announce: resigned(func(a *announcement) { a.Service = "Web" }),
+			err:      UnauthorizedAnnounceError,
+			message:  `identity "billing"`,
+		},
+		{
+			name:        "certificate identity",
+			announce:    announcement{Service: "Web"},
+			tlsIdentity: "frontend",
+			identity:    "frontend",
+		},
+		{
+			name:        "certificate identity mismatch",
+			announce:    announcement{Service: "Web", Identity: "billing"},
+			tlsIdentity: "frontend",
+			err:         UnauthenticatedAnnounceError,
+			message:     "does not match",
+		},
+	}
+	d := NewDispatcher("d")
+	d.SetAnnounceAuth(auth)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := d.authorize(test.announce, test.tlsIdentity)
+			if test.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if identity != test.identity {
+					t.Fatalf("got identity %q, want %q", identity, test.identity)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !errors.Is(err, test.err) || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("got %v, want %v with %q", err, test.err, test.message)
+			}
+		})
+	}
+}
+
+func TestAuthenticateCaller(t *testing.T) {
+	c, err := NewClient("billing", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCallerSecret([]byte("secret"))
+	signed := func(change func(req *request)) request {
+		req := c.sign(request{
+			ID:       "1",
+			Instance: Instance{Type: "Math", Version: "1.0.0", ID: []byte{1}},
+			Method:   "Add",
+			Params:   []byte{2},
+			Deadline: time.Now().Add(time.Minute),
+			Metadata: map[string]string{"tenant": "a"},
+			Caller:   "billing",
+		})
+		if change != nil {
+			change(&req)
+		}
+		return req
+	}
+	tests := []struct {
+		name    string
+		secrets map[string][]byte
+		req     request
+		message string
+	}{
+		{
+			name: "no caller secrets",
+			req:  request{Caller: "anyone"},
+		},
+		{
+			name:    "valid token",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(nil),
+		},
+		{
+			name:    "no token",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     request{Caller: "billing"},
+			message: "no credentials",
+		},
+		{
+			name:    "renamed caller",
+			secrets: map[string][]byte{"billing": []byte("secret"), "ops": []byte("other")},
+			req:     signed(func(req *request) { req.Caller = "ops" }),
+			message: "invalid token",
+		},
+		{
+			name:    "token reused for another method",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(func(req *request) { req.Method = "Divide" }),
+			message: "invalid token",
+		},
+		{
+			name:    "token reused with other params",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(func(req *request) { req.Params = []byte{3} }),
+			message: "invalid token",
+		},
+		{
+			name:    "token reused for another instance",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(func(req *request) { req.Instance.ID = []byte{4} }),
+			message: "invalid token",
+		},
+		{
+			name:    "token reused for another version",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(func(req *request) { req.Instance.Version = "2.0.0" }),
+			message: "invalid token",
+		},
+		{
+			name:    "token reused with other metadata",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(func(req *request) { req.Metadata = map[string]string{"tenant": "b"} }),
+			message: "invalid token",
+		},
+		{
+			name:    "token reused with a later deadline",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req:     signed(func(req *request) { req.Deadline = req.Deadline.Add(time.Hour) }),
+			message: "invalid token",
+		},
+		{
+			name:    "unknown caller",
+			secrets: map[string][]byte{"ops": []byte("other")},
+			req:     signed(nil),
+			message: "unknown identity",
+		},
+		{
+			name:    "expired token",
+			secrets: map[string][]byte{"billing": []byte("secret")},
+			req: signed(func(req *request) {
+				req.Timestamp = time.Now().Add(-2 * MaxAnnounceClockSkew).Unix()
+				req.Token = callerToken([]byte("secret"), *req)
+			}),
+			message: "expired",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer("s")
+			if test.secrets != nil {
+				s.SetCallerSecrets(test.secrets)
+			}
+			err := s.authenticateCaller(test.req)
+			if test.message == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || ErrorCode(err) != "Unauthenticated" || !strings.Contains(err.Error(), test.message) {
+				t.Fatalf("got %v, want an unauthenticated caller error with %q", err, test.message)
+			}
+		})
+	}
+}
+
+func TestReplayedCallerRequest(t *testing.T) {
+	c, err := NewClient("billing", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCallerSecret([]byte("secret"))
+	s := NewServer("s")
+	s.SetCallerSecrets(map[string][]byte{"billing": []byte("secret")})
+	req := c.sign(request{ID: "1", Instance: Instance{Type: "Math"}, Method: "Add", Caller: "billing"})
+	if err := s.authenticateCaller(req); err != nil {
+		t.Fatal(err)
+	}
+	err = s.authenticateCaller(req)
+	if !errors.Is(err, UnauthenticatedCallerError) || !strings.Contains(err.Error(), "already received") {
+		t.Fatalf("got %v, want a replay error", err)
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	start := time.Unix(1000, 0)
+	expires := start.Add(MaxAnnounceClockSkew)
+	r := newReplayCache()
+	steps := []struct {
+		name   string
+		caller string
+		id     string
+		now    time.Time
+		err    error
+	}{
+		{name: "first request", caller: "a", id: "1", now: start},
+		{name: "replay", caller: "a", id: "1", now: start.Add(time.Second), err: UnauthenticatedCallerError},
+		{name: "same ID from another caller", caller: "b", id: "1", now: start.Add(time.Second)},
+		{name: "another ID", caller: "a", id: "2", now: start.Add(time.Second)},
+		{name: "replay just before expiry", caller: "a", id: "1", now: expires.Add(-time.Second), err: UnauthenticatedCallerError},
+		{name: "after expiry", caller: "a", id: "1", now: expires},
+	}
+	for _, step := range steps {
+		err := r.check(step.caller, step.id, expires, step.now)
+		if step.err == nil && err != nil || !errors.Is(err, step.err) {
+			t.Fatalf("%v: got %v, want %v", step.name, err, step.err)
+		}
+	}
+	r = newReplayCache()
+	for i := 0; i < maxReplayEntries; i++ {
+		r.seen[replayKey{"a", strconv.Itoa(i)}] = expires
+	}
+	if err := r.check("a", "new", expires, start); err != ResourceExhaustedError {
+		t.Fatalf("got %v, want %v", err, ResourceExhaustedError)
+	}
+	if err := r.check("a", "new", expires, expires); err != nil {
+		t.Fatalf("expired entries were not swept: %v", err)
+	}
+	if len(r.seen) != 1 {
+		t.Fatalf("%v entries left after sweeping", len(r.seen))
+	}
+}
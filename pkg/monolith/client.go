@@ -2,12 +2,17 @@ package monolith
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"io"
 	"net"
 	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var proxies = make(map[reflect.Type]func(i Instance) any)
@@ -17,79 +22,306 @@ func RegisterProxy[T any](create func(i Instance) any) {
 	proxies[t] = create
 }
 
+type route struct {
+	encoder  *frameEncoder
+	version  string
+	service  string
+	endPoint string
+	schema   Schema
+	state    *routeState
+}
+
+type routeState struct {
+	conn     net.Conn
+	inFlight int
+	retired  bool
+	closed   chan struct{}
+	err      error
+	lock     sync.Mutex
+}
+
+func (s *routeState) acquire() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.retired {
+		return false
+	}
+	s.inFlight++
+	return true
+}
+
+func (s *routeState) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inFlight--
+	if s.retired && s.inFlight == 0 {
+		_ = s.conn.Close()
+	}
+}
+
+func (s *routeState) retire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.retired {
+		return
+	}
+	s.retired = true
+	if s.inFlight == 0 {
+		_ = s.conn.Close()
+	}
+}
+
+type GetOption func(i *Instance) error
+
+func WithVersion(constraint string) GetOption {
+	return func(i *Instance) (err error) {
+		i.constraint, err = ParseVersionConstraint(constraint)
+		return
+	}
+}
+
+func WithSelector(selector string) GetOption {
+	return func(i *Instance) (err error) {
+		i.selector, err = ParseSelector(selector)
+		return
+	}
+}
+
+func WithTimeout(timeout time.Duration) GetOption {
+	return func(i *Instance) error {
+		i.timeout = timeout
+		return nil
+	}
+}
+
+func WithContext(ctx context.Context) GetOption {
+	return func(i *Instance) error {
+		i.ctx = ctx
+		return nil
+	}
+}
+
+func WithMetadata(key, value string) GetOption {
+	return func(i *Instance) error {
+		metadata := make(map[string]string, len(i.metadata)+1)
+		for k, v := range i.metadata {
+			metadata[k] = v
+		}
+		metadata[key] = value
+		i.metadata = metadata
+		return nil
+	}
+}
+
+func WithPreference(preference string) GetOption {
+	return func(i *Instance) error {
+		p, err := ParseSelector(preference)
+		if err != nil {
+			return err
+		}
+		i.preferences = append(i.preferences, p)
+		return nil
+	}
+}
+
 type Client struct {
-	name              string
-	requestRoutes     SyncMap[string, *gob.Encoder]
-	responseRoutes    SyncMap[string, chan response]
-	address           *net.TCPAddr
-	dispatcherAddress *net.TCPAddr
-	logger            *log.Logger
+	name           string
+	requestRoutes  SyncMap[string, route]
+	responseRoutes SyncMap[string, chan response]
+	address        *net.TCPAddr
+	dispatchers    *endPointPool
+	watches        *watchSession
+	resolver       Resolver
+	gateway        string
+	namespace      string
+	metrics        Metrics
+	frames         FrameLimits
+	exporter       SpanExporter
+	sampler        traceSampler
+	spanContext    SpanContext
+	secret         []byte
+	connections    *atomic.Int64
+	done           context.Context
+	stop           context.CancelFunc
+	logger         nodeLogger
 }
 
-func NewClient(name, endPoint, dispatcherEndPoint string) (client Client, err error) {
+func NewClient(name, endPoint string, dispatcherEndPoints ...string) (client Client, err error) {
 	address, err := net.ResolveTCPAddr("tcp", endPoint)
 	if err != nil {
 		return
 	}
-	dispatcherAddress, err := net.ResolveTCPAddr("tcp", dispatcherEndPoint)
-	if err != nil {
-		return
+	for _, dispatcherEndPoint := range dispatcherEndPoints {
+		_, err = net.ResolveTCPAddr("tcp", dispatcherEndPoint)
+		if err != nil {
+			return
+		}
 	}
+	done, stop := context.WithCancel(context.Background())
 	client = Client{
-		name:              name,
-		requestRoutes:     NewSyncMap[string, *gob.Encoder](),
-		responseRoutes:    NewSyncMap[string, chan response](),
-		address:           address,
-		dispatcherAddress: dispatcherAddress,
-		logger:            log.Default(),
+		name:           name,
+		requestRoutes:  NewSyncMap[string, route](),
+		responseRoutes: NewSyncMap[string, chan response](),
+		address:        address,
+		dispatchers:    newEndPointPool(dispatcherEndPoints),
+		watches:        newWatchSession(),
+		metrics:        noMetrics{},
+		frames:         DefaultFrameLimits,
+		connections:    &atomic.Int64{},
+		done:           done,
+		stop:           stop,
+		logger:         newNodeLogger("client", name, nil),
 	}
 	return
 }
 
-func (c *Client) logf(format string, v ...any) {
-	if c.logger == nil {
-		return
+func (c *Client) Close() {
+	c.stop()
+	c.watches.close()
+	routes := c.requestRoutes.removeWhere(func(string, route) bool {
+		return true
+	})
+	for _, r := range routes {
+		r.state.retire()
+	}
+}
+
+func (c *Client) UseGateway(endPoint string) {
+	c.gateway = endPoint
+}
+
+func (c *Client) retireRoutes(match func(r route) bool) {
+	routes := c.requestRoutes.removeWhere(func(_ string, r route) bool {
+		return match(r)
+	})
+	for _, r := range routes {
+		c.logger.info("moving traffic off server", "service", r.service, "endpoint", r.endPoint)
+		r.state.retire()
 	}
-	message := fmt.Sprintf(format, v...)
-	c.logger.Printf("Client '%v': %v\n", c.name, message)
 }
 
-func (c *Client) log(v ...any) {
-	if c.logger == nil {
+func (c *Client) SetDispatcherOrder(order EndPointOrder) {
+	c.dispatchers.setOrder(order)
+}
+
+func Get[T any, ID comparable](id ID, client *Client, options ...GetOption) (proxy T, err error) {
+	encoded, err := EncodeID(id)
+	if err != nil {
 		return
 	}
-	message := fmt.Sprint(v...)
-	c.logger.Printf("Client '%v': %v\n", c.name, message)
+	return get[T](encoded, client, options)
+}
+
+func GetSingleton[T any](client *Client, options ...GetOption) (proxy T, err error) {
+	return get[T](nil, client, options)
 }
 
-func Get[T any](id string, client *Client) (proxy T, err error) {
+func get[T any](id []byte, client *Client, options []GetOption) (proxy T, err error) {
 	t := reflect.TypeOf((*T)(nil)).Elem()
 	i := Instance{
-		ID:     id,
-		Type:   t.Name(),
-		client: client,
+		ID:        id,
+		Type:      t.Name(),
+		client:    client,
+		namespace: client.namespace,
 	}
 	p, ok := proxies[t]
 	if !ok {
 		err = ProxyTypeNotFoundError
 		return
 	}
+	if schema, ok := proxySchemas[t]; ok {
+		i.schema = &schema
+		if schema.Version != "" {
+			i.constraint, err = ParseVersionConstraint("^" + schema.Version)
+			if err != nil {
+				return
+			}
+		}
+	}
+	for _, option := range options {
+		err = option(&i)
+		if err != nil {
+			return
+		}
+	}
 	return p(i).(T), nil
 }
 
+func (i Instance) lookup() lookup {
+	l := lookup{
+		Namespace: i.namespace,
+		Service:   i.Type,
+		Version:   i.constraint.String(),
+		Selector:  i.selector.String(),
+		Exclude:   i.exclude,
+	}
+	for _, p := range i.preferences {
+		l.Preferences = append(l.Preferences, p.String())
+	}
+	return l
+}
+
+func (i Instance) routeKey() string {
+	l := i.lookup()
+	return fmt.Sprintf("%v@%v[%v]%v", qualify(l.Namespace, l.Service), l.Version, l.Selector, l.Preferences)
+}
+
 func (i Instance) Call(method string, params any, results any) (err error) {
 	var buffer bytes.Buffer
 	err = gob.NewEncoder(&buffer).Encode(params)
 	if err != nil {
 		return
 	}
+	err = i.client.frames.checkParams(buffer.Bytes())
+	if err != nil {
+		return
+	}
 	req := request{
 		ID:       uuid.NewString(),
 		Instance: i,
 		Method:   method,
 		Params:   buffer.Bytes(),
+		Metadata: i.metadata,
+		Caller:   i.client.name,
 	}
+	if i.timeout > 0 {
+		req.Deadline = time.Now().Add(i.timeout)
+	}
+	if i.ctx != nil {
+		deadline, ok := i.ctx.Deadline()
+		if ok && (req.Deadline.IsZero() || deadline.Before(req.Deadline)) {
+			req.Deadline = deadline
+		}
+	}
+	parent, ok := SpanContextFromContext(i.ctx)
+	if !ok {
+		parent = i.client.spanContext
+	}
+	span, sc := startSpan(i.client.exporter, i.client.sampler, parent, ClientSpan, i.Type, method)
+	if sc.IsValid() {
+		metadata := make(map[string]string, len(req.Metadata)+1)
+		for k, v := range req.Metadata {
+			metadata[k] = v
+		}
+		metadata[TraceParentKey] = sc.TraceParent()
+		req.Metadata = metadata
+	}
+	span.set("request.id", req.ID)
+	start := time.Now()
 	res := i.send(req)
+	elapsed := time.Since(start)
+	i.client.observeCall(i.Type, method, res.sent, res.received, res.Err, elapsed)
+	if res.Err != nil {
+		i.client.logger.debug("call failed", "service", i.Type, "method", method,
+			"request_id", req.ID, "duration", elapsed, "error", res.Err)
+	} else {
+		i.client.logger.request("call finished", "service", i.Type, "method", method,
+			"request_id", req.ID, "duration", elapsed)
+	}
+	exportErr := span.finish(res.Err)
+	if exportErr != nil {
+		i.client.logger.warn("failed to export span", "error", exportErr)
+	}
 	if res.Err != nil {
 		return res.Err
 	}
@@ -97,35 +329,121 @@ func (i Instance) Call(method string, params any, results any) (err error) {
 }
 
 func (i Instance) send(req request) (res response) {
-	encoder, ok := i.client.requestRoutes.get(i.Type)
-	if !ok {
-		encoder, res.Err = i.connect()
+	res, endPoint := i.sendTo(req)
+	for attempt := 0; attempt < capacityRetries && i.client.gateway == ""; attempt++ {
+		if !errors.Is(res.Err, ResourceExhaustedError) {
+			return
+		}
+		i.client.logger.debug("server is at capacity, trying another endpoint", "service", i.Type,
+			"request_id", req.ID, "endpoint", endPoint)
+		i.exclude = append(i.exclude[:len(i.exclude):len(i.exclude)], endPoint)
+		retried, next := i.sendTo(req)
+		if errors.Is(retried.Err, ServiceNotFoundError) {
+			return
+		}
+		res, endPoint = retried, next
+	}
+	return
+}
+
+func (i Instance) sendTo(req request) (res response, endPoint string) {
+	key := i.routeKey()
+	if i.client.gateway != "" {
+		key = gatewayRouteKey
+		l := i.lookup()
+		l.Schema = i.schema
+		req.Lookup = &l
+	}
+	r, ok := i.client.requestRoutes.get(key)
+	if ok && excluded(i.exclude, r.endPoint) {
+		i.client.retireRoutes(func(other route) bool {
+			return other.state == r.state
+		})
+		ok = false
+	}
+	if !ok || !r.state.acquire() {
+		r, res.Err = i.connect()
 		if res.Err != nil {
 			return
 		}
 	}
-	route := make(chan response)
-	i.client.responseRoutes.put(req.ID, route)
+	defer r.state.release()
+	endPoint = r.endPoint
+	// Gateway routes are shared by clients built from different schemas.
+	if i.schema != nil && i.schema.Hash != r.schema.Hash {
+		res.Err = i.schema.CheckCompatible(r.schema)
+		if res.Err != nil {
+			return
+		}
+	}
+	// Signed requests relayed by a gateway keep the version their token
+	// covers, so the server runs its latest version of the type.
+	if req.Lookup == nil && req.Token == "" {
+		req.Instance.Version = r.version
+	}
+	req = i.client.sign(req)
+	responses := make(chan response, 1)
+	i.client.responseRoutes.put(req.ID, responses)
 	defer i.client.responseRoutes.delete(req.ID)
-	res.Err = encoder.Encode(req)
-	if res.Err != nil {
+	var expired <-chan time.Time
+	if !req.Deadline.IsZero() {
+		timer := time.NewTimer(time.Until(req.Deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var canceled <-chan struct{}
+	if i.ctx != nil {
+		canceled = i.ctx.Done()
+	}
+	sent, err := r.encoder.encodeSized(req)
+	if err != nil {
+		res.Err = err
 		return
 	}
-	i.client.log("sent request with ID ", req.ID)
-	res = <-route
+	defer func() {
+		res.sent = sent
+	}()
+	select {
+	case res = <-responses:
+	case <-expired:
+		res.Err = DeadlineExceededError
+	case <-r.state.closed:
+		res.Err = ConnectionClosedError
+		if r.state.err != nil {
+			res.Err = r.state.err
+		}
+	case <-canceled:
+		res.Err = i.ctx.Err()
+	}
 	return
 }
 
-func (i Instance) connect() (encoder *gob.Encoder, err error) {
-	endPoint, err := i.getEndPoint()
+func (i Instance) connect() (r route, err error) {
+	if i.client.gateway != "" {
+		return i.dial(gatewayRouteKey, registration{
+			EndPoint: i.client.gateway,
+		})
+	}
+	reg, err := i.getEndPoint()
 	if err != nil {
 		return
 	}
-	if endPoint == "" {
-		return nil, ServiceNotFoundError
+	if reg.EndPoint == "" {
+		err = ServiceNotFoundError
+		return
 	}
-	i.client.logf("received endpoint %v for service '%v'", endPoint, i.Type)
-	serviceAddress, err := net.ResolveTCPAddr("tcp", endPoint)
+	i.client.logger.debug("received endpoint", "service", i.Type, "version", reg.Version, "endpoint", reg.EndPoint)
+	if i.schema != nil {
+		err = i.schema.CheckCompatible(reg.Schema)
+		if err != nil {
+			return
+		}
+	}
+	return i.dial(i.routeKey(), reg)
+}
+
+func (i Instance) dial(key string, reg registration) (r route, err error) {
+	serviceAddress, err := net.ResolveTCPAddr("tcp", reg.EndPoint)
 	if err != nil {
 		return
 	}
@@ -134,39 +452,102 @@ func (i Instance) connect() (encoder *gob.Encoder, err error) {
 		return
 	}
 	remote := conn.RemoteAddr().String()
-	i.client.log("connected to server ", remote)
-	encoder = gob.NewEncoder(conn)
-	i.client.requestRoutes.put(i.Type, encoder)
+	i.client.logger.debug("connected to server", "peer", remote)
+	i.client.countConnection(1)
+	state := &routeState{
+		conn:     conn,
+		inFlight: 1,
+		closed:   make(chan struct{}),
+	}
+	r = route{
+		encoder:  newFrameEncoder(conn, i.client.frames),
+		version:  reg.Version,
+		service:  i.Type,
+		endPoint: reg.EndPoint,
+		schema:   reg.Schema,
+		state:    state,
+	}
+	i.client.requestRoutes.put(key, r)
 	go func() {
+		defer close(state.closed)
 		defer func() {
+			i.client.countConnection(-1)
 			err := conn.Close()
-			if err != nil {
-				i.client.log(err)
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				i.client.logger.warn("failed to close connection", "peer", remote, "error", err)
 			}
-			i.client.requestRoutes.delete(i.Type)
+			i.client.requestRoutes.removeWhere(func(k string, r route) bool {
+				return k == key && r.state == state
+			})
+			state.retire()
 		}()
-		decoder := gob.NewDecoder(conn)
+		decoder := newFrameDecoder(conn, i.client.frames)
 		for {
 			var res response
-			err := decoder.Decode(&res)
+			size, err := decoder.decodeSized(&res)
 			if err != nil {
-				i.client.log(err)
+				if errors.Is(err, FrameTooLargeError) {
+					state.err = err
+				}
+				if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					i.client.logger.warn("server connection failed", "peer", remote, "error", err)
+				}
 				return
 			}
-			i.client.log("received response with ID ", res.ID, " from server ", remote)
-			route, ok := i.client.responseRoutes.get(res.ID)
+			if res.ID == "" {
+				state.err = res.Err
+				i.client.logger.warn("server rejected the connection", "peer", remote, "error", res.Err)
+				return
+			}
+			responses, ok := i.client.responseRoutes.get(res.ID)
 			if !ok {
-				i.client.log(RequestNotFoundError)
+				i.client.logger.debug("dropped response for a request that is no longer waiting", "request_id", res.ID, "peer", remote)
 				continue
 			}
-			route <- res
+			res.received = size
+			responses <- res
 		}
 	}()
 	return
 }
 
-func (i Instance) getEndPoint() (endPoint string, err error) {
-	conn, err := net.DialTCP("tcp", i.client.address, i.client.dispatcherAddress)
+func (i Instance) getEndPoint() (reg registration, err error) {
+	if i.client.resolver != nil {
+		return i.resolveEndPoint()
+	}
+	if len(i.client.dispatchers.endPoints) == 0 {
+		err = NoResolverError
+		return
+	}
+	reg, err = i.client.watches.endPoint(i.client, i)
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, ServiceNotFoundError):
+		// Dispatchers outside a cluster only know the servers that announced to them.
+		i.client.logger.debug("watched dispatcher has no endpoint, asking the others", "service", i.Type)
+	default:
+		i.client.logger.warn("watch failed, falling back to lookup", "service", i.Type, "error", err)
+	}
+	err = i.client.dispatchers.try(func(dispatcherEndPoint string) (err error) {
+		reg, err = i.lookupEndPoint(dispatcherEndPoint)
+		switch {
+		case errors.Is(err, ServiceNotFoundError):
+			i.client.logger.debug("dispatcher has no endpoint", "dispatcher", dispatcherEndPoint, "service", i.Type)
+		case err != nil:
+			i.client.logger.warn("dispatcher failed", "dispatcher", dispatcherEndPoint, "error", err)
+		}
+		return
+	})
+	return
+}
+
+func (i Instance) lookupEndPoint(dispatcherEndPoint string) (reg registration, err error) {
+	dispatcherAddress, err := net.ResolveTCPAddr("tcp", dispatcherEndPoint)
+	if err != nil {
+		return
+	}
+	conn, err := net.DialTCP("tcp", i.client.address, dispatcherAddress)
 	if err != nil {
 		return
 	}
@@ -174,18 +555,33 @@ func (i Instance) getEndPoint() (endPoint string, err error) {
 		closeErr := conn.Close()
 		if err == nil {
 			err = closeErr
-		} else {
-			i.client.log(closeErr)
+		} else if closeErr != nil {
+			i.client.logger.warn("failed to close connection", "error", closeErr)
 		}
 	}()
-	i.client.log("connected to dispatcher ", i.client.dispatcherAddress.String())
-	encoder := gob.NewEncoder(conn)
-	decoder := gob.NewDecoder(conn)
-	err = encoder.Encode(i.Type)
-	i.client.logf("requested endpoint for service '%v'", i.Type)
+	encoder := newFrameEncoder(conn, i.client.frames)
+	decoder := newFrameDecoder(conn, i.client.frames)
+	l := i.lookup()
+	err = encoder.Encode(clientMessage{
+		Lookup: &l,
+	})
+	i.client.logger.debug("requested endpoint", "dispatcher", dispatcherEndPoint, "service", l.Service,
+		"version", l.Version, "selector", l.Selector, "preferences", l.Preferences)
 	if err != nil {
 		return
 	}
-	err = decoder.Decode(&endPoint)
+	var m dispatcherMessage
+	err = decoder.Decode(&m)
+	if err != nil {
+		return
+	}
+	switch {
+	case m.Registration == nil:
+		err = UnexpectedMessageError
+	case m.Registration.EndPoint == "":
+		err = ServiceNotFoundError
+	default:
+		reg = *m.Registration
+	}
 	return
 }
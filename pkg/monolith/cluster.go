@@ -0,0 +1,893 @@
+package monolith
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	heartbeatInterval  = 50 * time.Millisecond
+	minElectionTimeout = 300 * time.Millisecond
+	maxElectionTimeout = 600 * time.Millisecond
+	clusterCallTimeout = 250 * time.Millisecond
+	proposalTimeout    = 3 * time.Second
+	maxAppendEntries   = 256
+)
+
+type nodeState int
+
+const (
+	follower nodeState = iota
+	candidate
+	leader
+)
+
+func (s nodeState) String() string {
+	switch s {
+	case candidate:
+		return "candidate"
+	case leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+type logEntry struct {
+	Term uint64
+	Op   registryOp
+}
+
+type voteRequest struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type voteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+type appendRequest struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []logEntry
+	LeaderCommit uint64
+}
+
+type appendResponse struct {
+	Term      uint64
+	Success   bool
+	LastIndex uint64
+}
+
+type snapshotRequest struct {
+	Term      uint64
+	Leader    string
+	LastIndex uint64
+	LastTerm  uint64
+	Ops       []registryOp
+}
+
+type snapshotResponse struct {
+	Term uint64
+}
+
+type forwardRequest struct {
+	Op registryOp
+}
+
+type forwardResponse struct {
+	Err error
+}
+
+type clusterRequest struct {
+	Vote     *voteRequest
+	Append   *appendRequest
+	Snapshot *snapshotRequest
+	Forward  *forwardRequest
+}
+
+type clusterResponse struct {
+	Vote     *voteResponse
+	Append   *appendResponse
+	Snapshot *snapshotResponse
+	Forward  *forwardResponse
+}
+
+type peer struct {
+	id          string
+	endPoint    string
+	lock        sync.Mutex
+	replicating sync.Mutex
+	conn        net.Conn
+	frames      FrameLimits
+	encoder     *frameEncoder
+	decoder     *frameDecoder
+}
+
+func (p *peer) call(req clusterRequest, timeout time.Duration) (res clusterResponse, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn == nil {
+		p.conn, err = net.DialTimeout("tcp", p.endPoint, timeout)
+		if err != nil {
+			return
+		}
+		p.encoder = newFrameEncoder(p.conn, p.frames)
+		p.decoder = newFrameDecoder(p.conn, p.frames)
+	}
+	defer func() {
+		if err != nil {
+			p.reset()
+		}
+	}()
+	err = p.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return
+	}
+	err = p.encoder.Encode(req)
+	if err != nil {
+		return
+	}
+	err = p.decoder.Decode(&res)
+	return
+}
+
+func (p *peer) reset() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	p.conn = nil
+}
+
+func (p *peer) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.reset()
+}
+
+type cluster struct {
+	d                *Dispatcher
+	id               string
+	peers            map[string]*peer
+	lock             sync.Mutex
+	state            nodeState
+	term             uint64
+	votedFor         string
+	leader           string
+	store            *clusterStore
+	snapshotIndex    uint64
+	snapshotTerm     uint64
+	entries          []logEntry
+	commitIndex      uint64
+	lastApplied      uint64
+	nextIndex        map[string]uint64
+	matchIndex       map[string]uint64
+	electionDeadline time.Time
+	committed        chan struct{}
+}
+
+func newCluster(d *Dispatcher, id string, peers map[string]string) *cluster {
+	c := &cluster{
+		d:          d,
+		id:         id,
+		peers:      make(map[string]*peer),
+		nextIndex:  make(map[string]uint64),
+		matchIndex: make(map[string]uint64),
+		committed:  make(chan struct{}),
+	}
+	for peerID, endPoint := range peers {
+		if peerID == id {
+			continue
+		}
+		c.peers[peerID] = &peer{
+			id:       peerID,
+			endPoint: endPoint,
+			frames:   d.frames.withoutTimeouts(),
+		}
+	}
+	c.resetElection()
+	return c
+}
+
+func (d *Dispatcher) JoinCluster(id, endPoint string, peers map[string]string) (err error) {
+	local, err := net.ResolveTCPAddr("tcp", endPoint)
+	if err != nil {
+		return
+	}
+	listener, err := net.ListenTCP("tcp", local)
+	if err != nil {
+		return
+	}
+	c := newCluster(d, id, peers)
+	err = d.restoreCluster(c)
+	if err != nil {
+		_ = listener.Close()
+		return
+	}
+	d.logger.info("started listening cluster peers", "node", id, "endpoint", endPoint)
+	d.applyLock.Lock()
+	d.cluster = c
+	d.applyLock.Unlock()
+	d.listeners = append(d.listeners, listener)
+	var wg sync.WaitGroup
+	d.wgs = append(d.wgs, &wg)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.run()
+	}()
+	go func() {
+		defer d.logger.info("stopped listening cluster peers", "endpoint", endPoint)
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					d.logger.error("failed to accept connection", "error", err)
+				}
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := d.serveCluster(conn)
+				if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					d.logger.warn("cluster peer connection failed", "peer", conn.RemoteAddr(), "error", err)
+				}
+			}()
+		}
+	}()
+	return
+}
+
+func (d *Dispatcher) restoreCluster(c *cluster) (err error) {
+	var path string
+	var s *clusterStore
+	var state clusterState
+	var snapshot clusterSnapshot
+	var entries []logEntry
+	d.applyLock.Lock()
+	local := d.store
+	if local != nil {
+		path = local.path + ".raft"
+		s, state, snapshot, entries, err = openClusterStore(path)
+		if err == nil {
+			err = local.close()
+		}
+		if err == nil {
+			// The replicated log owns the registry from now on, the local store would diverge from it.
+			d.store = nil
+		}
+	}
+	d.applyLock.Unlock()
+	if local == nil {
+		d.logger.warn("cluster state is kept in memory only, call Persist before JoinCluster to survive restarts",
+			"node", c.id)
+		return
+	}
+	if err != nil {
+		return
+	}
+	c.store = s
+	c.term, c.votedFor = state.Term, state.VotedFor
+	c.snapshotIndex, c.snapshotTerm, c.entries = snapshot.Index, snapshot.Term, entries
+	c.commitIndex, c.lastApplied = snapshot.Index, snapshot.Index
+	d.restore(snapshot.Ops)
+	d.logger.info("restored cluster state", "node", c.id, "term", c.term,
+		"snapshot", snapshot.Index, "entries", len(entries), "path", path)
+	return
+}
+
+func (d *Dispatcher) Leader() string {
+	c := d.clusterNode()
+	if c == nil {
+		return ""
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.leader
+}
+
+func (d *Dispatcher) serveCluster(conn net.Conn) (err error) {
+	defer d.track(conn, "peer")()
+	defer func() {
+		closeErr := conn.Close()
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			if err == nil {
+				err = closeErr
+			} else {
+				d.logger.warn("failed to close connection", "error", closeErr)
+			}
+		}
+	}()
+	decoder := newFrameDecoder(conn, d.frames)
+	encoder := newFrameEncoder(conn, d.frames)
+	for {
+		var req clusterRequest
+		err = decoder.Decode(&req)
+		if err != nil {
+			return
+		}
+		var res clusterResponse
+		switch {
+		case req.Vote != nil:
+			res.Vote = d.cluster.handleVote(*req.Vote)
+		case req.Append != nil:
+			res.Append = d.cluster.handleAppend(*req.Append)
+		case req.Snapshot != nil:
+			res.Snapshot = d.cluster.handleSnapshot(*req.Snapshot)
+		case req.Forward != nil:
+			res.Forward = d.cluster.handleForward(*req.Forward)
+		}
+		err = encoder.Encode(res)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *cluster) majority() int {
+	return (len(c.peers)+1)/2 + 1
+}
+
+func (c *cluster) resetElection() {
+	timeout := minElectionTimeout + time.Duration(rand.Int63n(int64(maxElectionTimeout-minElectionTimeout)))
+	c.electionDeadline = time.Now().Add(timeout)
+}
+
+func (c *cluster) lastIndex() uint64 {
+	return c.snapshotIndex + uint64(len(c.entries))
+}
+
+func (c *cluster) lastLog() (index, term uint64) {
+	index = c.lastIndex()
+	return index, c.termAt(index)
+}
+
+func (c *cluster) termAt(index uint64) uint64 {
+	switch {
+	case index == c.snapshotIndex:
+		return c.snapshotTerm
+	case index < c.snapshotIndex || index > c.lastIndex():
+		return 0
+	}
+	return c.entry(index).Term
+}
+
+func (c *cluster) entry(index uint64) logEntry {
+	return c.entries[index-c.snapshotIndex-1]
+}
+
+func (c *cluster) persistState() error {
+	if c.store == nil {
+		return nil
+	}
+	err := c.store.saveState(clusterState{
+		Term:     c.term,
+		VotedFor: c.votedFor,
+	})
+	if err != nil {
+		c.d.logger.error("failed to persist cluster state", "node", c.id, "error", err)
+	}
+	return err
+}
+
+func (c *cluster) appendEntries(entries ...logEntry) error {
+	if c.store != nil {
+		err := c.store.append(c.lastIndex()+1, entries)
+		if err != nil {
+			c.d.logger.error("failed to persist cluster log", "node", c.id, "error", err)
+			return err
+		}
+	}
+	c.entries = append(c.entries, entries...)
+	return nil
+}
+
+func (c *cluster) truncate(index uint64) error {
+	c.entries = c.entries[:index-c.snapshotIndex-1]
+	if c.store == nil {
+		return nil
+	}
+	err := c.store.rewrite(c.snapshotIndex+1, c.entries)
+	if err != nil {
+		c.d.logger.error("failed to persist cluster log", "node", c.id, "error", err)
+	}
+	return err
+}
+
+func (c *cluster) run() {
+	ticker := time.NewTicker(heartbeatInterval / 5)
+	defer ticker.Stop()
+	var lastHeartbeat time.Time
+	for {
+		select {
+		case <-c.d.done.Done():
+			for _, p := range c.peers {
+				p.close()
+			}
+			return
+		case <-ticker.C:
+		}
+		c.lock.Lock()
+		heartbeat := false
+		switch {
+		case c.state == leader:
+			heartbeat = time.Since(lastHeartbeat) >= heartbeatInterval
+		case time.Now().After(c.electionDeadline):
+			c.startElection()
+		}
+		c.lock.Unlock()
+		if heartbeat {
+			lastHeartbeat = time.Now()
+			c.replicateAll()
+		}
+	}
+}
+
+func (c *cluster) startElection() {
+	c.state = candidate
+	c.term++
+	c.votedFor = c.id
+	c.leader = ""
+	c.resetElection()
+	if c.persistState() != nil {
+		c.state = follower
+		return
+	}
+	term := c.term
+	lastIndex, lastTerm := c.lastLog()
+	c.d.logger.info("started election", "node", c.id, "term", term)
+	votes := 1
+	if votes >= c.majority() {
+		c.becomeLeader()
+		return
+	}
+	req := clusterRequest{
+		Vote: &voteRequest{
+			Term:         term,
+			Candidate:    c.id,
+			LastLogIndex: lastIndex,
+			LastLogTerm:  lastTerm,
+		},
+	}
+	for _, p := range c.peers {
+		go func(p *peer) {
+			res, err := p.call(req, clusterCallTimeout)
+			if err != nil || res.Vote == nil {
+				return
+			}
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if res.Vote.Term > c.term {
+				c.stepDown(res.Vote.Term)
+				return
+			}
+			if c.state != candidate || c.term != term || !res.Vote.Granted {
+				return
+			}
+			votes++
+			if votes >= c.majority() {
+				c.becomeLeader()
+			}
+		}(p)
+	}
+}
+
+func (c *cluster) becomeLeader() {
+	c.state = leader
+	c.leader = c.id
+	next := c.lastIndex() + 1
+	for id := range c.peers {
+		c.nextIndex[id] = next
+		c.matchIndex[id] = 0
+	}
+	// An empty entry from the new term lets entries of previous terms commit.
+	if c.appendEntries(logEntry{
+		Term: c.term,
+	}) == nil {
+		c.advanceCommit()
+	}
+	c.d.logger.info("became leader", "node", c.id, "term", c.term)
+	go c.replicateAll()
+}
+
+func (c *cluster) stepDown(term uint64) {
+	if term > c.term {
+		c.term = term
+		c.votedFor = ""
+		_ = c.persistState()
+	}
+	if c.state != follower {
+		c.d.logger.info("became follower", "node", c.id, "term", c.term)
+	}
+	c.state = follower
+	c.resetElection()
+}
+
+func (c *cluster) replicateAll() {
+	for _, p := range c.peers {
+		go c.replicate(p)
+	}
+}
+
+func (c *cluster) replicate(p *peer) {
+	if !p.replicating.TryLock() {
+		return
+	}
+	defer p.replicating.Unlock()
+	c.lock.Lock()
+	if c.state != leader {
+		c.lock.Unlock()
+		return
+	}
+	term := c.term
+	next := c.nextIndex[p.id]
+	if next <= c.snapshotIndex {
+		c.sendSnapshot(p)
+		return
+	}
+	prevIndex := next - 1
+	last := c.lastIndex()
+	if last-prevIndex > maxAppendEntries {
+		last = prevIndex + maxAppendEntries
+	}
+	entries := make([]logEntry, last-prevIndex)
+	copy(entries, c.entries[prevIndex-c.snapshotIndex:last-c.snapshotIndex])
+	req := clusterRequest{
+		Append: &appendRequest{
+			Term:         term,
+			Leader:       c.id,
+			PrevLogIndex: prevIndex,
+			PrevLogTerm:  c.termAt(prevIndex),
+			Entries:      entries,
+			LeaderCommit: c.commitIndex,
+		},
+	}
+	c.lock.Unlock()
+	res, err := p.call(req, clusterCallTimeout)
+	if err != nil || res.Append == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if res.Append.Term > c.term {
+		c.stepDown(res.Append.Term)
+		return
+	}
+	if c.state != leader || c.term != term {
+		return
+	}
+	if !res.Append.Success {
+		next = res.Append.LastIndex + 1
+		if next > c.nextIndex[p.id] {
+			next = c.nextIndex[p.id] - 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		c.nextIndex[p.id] = next
+		return
+	}
+	match := prevIndex + uint64(len(entries))
+	if match > c.matchIndex[p.id] {
+		c.matchIndex[p.id] = match
+	}
+	c.nextIndex[p.id] = match + 1
+	c.advanceCommit()
+}
+
+func (c *cluster) advanceCommit() {
+	for n := c.lastIndex(); n > c.commitIndex; n-- {
+		if c.termAt(n) != c.term {
+			break
+		}
+		count := 1
+		for id := range c.peers {
+			if c.matchIndex[id] >= n {
+				count++
+			}
+		}
+		if count >= c.majority() {
+			c.commitIndex = n
+			c.applyCommitted()
+			return
+		}
+	}
+}
+
+func (c *cluster) applyCommitted() {
+	for c.lastApplied < c.commitIndex {
+		c.lastApplied++
+		op := c.entry(c.lastApplied).Op
+		if op.Kind != "" {
+			c.d.commit(op)
+		}
+	}
+	close(c.committed)
+	c.committed = make(chan struct{})
+	if c.lastApplied-c.snapshotIndex >= compactionThreshold {
+		c.compact()
+	}
+}
+
+func (c *cluster) compact() {
+	term := c.termAt(c.lastApplied)
+	entries := make([]logEntry, c.lastIndex()-c.lastApplied)
+	copy(entries, c.entries[c.lastApplied-c.snapshotIndex:])
+	if c.store != nil {
+		err := c.store.saveSnapshot(clusterSnapshot{
+			Index: c.lastApplied,
+			Term:  term,
+			Ops:   c.d.services.ops(),
+		}, entries)
+		if err != nil {
+			c.d.logger.error("failed to persist cluster snapshot", "node", c.id, "error", err)
+			return
+		}
+	}
+	c.snapshotIndex, c.snapshotTerm, c.entries = c.lastApplied, term, entries
+}
+
+func (c *cluster) sendSnapshot(p *peer) {
+	term := c.term
+	req := clusterRequest{
+		Snapshot: &snapshotRequest{
+			Term:      term,
+			Leader:    c.id,
+			LastIndex: c.lastApplied,
+			LastTerm:  c.termAt(c.lastApplied),
+			Ops:       c.d.services.ops(),
+		},
+	}
+	c.lock.Unlock()
+	res, err := p.call(req, clusterCallTimeout)
+	if err != nil || res.Snapshot == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if res.Snapshot.Term > c.term {
+		c.stepDown(res.Snapshot.Term)
+		return
+	}
+	if c.state != leader || c.term != term {
+		return
+	}
+	if req.Snapshot.LastIndex > c.matchIndex[p.id] {
+		c.matchIndex[p.id] = req.Snapshot.LastIndex
+	}
+	c.nextIndex[p.id] = req.Snapshot.LastIndex + 1
+	c.advanceCommit()
+}
+
+func (c *cluster) handleSnapshot(req snapshotRequest) *snapshotResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if req.Term < c.term {
+		return &snapshotResponse{
+			Term: c.term,
+		}
+	}
+	if req.Term > c.term || c.state != follower {
+		c.stepDown(req.Term)
+	}
+	c.leader = req.Leader
+	c.resetElection()
+	res := snapshotResponse{
+		Term: c.term,
+	}
+	if req.LastIndex <= c.lastApplied {
+		return &res
+	}
+	var entries []logEntry
+	if c.termAt(req.LastIndex) == req.LastTerm && req.LastIndex <= c.lastIndex() {
+		entries = append(entries, c.entries[req.LastIndex-c.snapshotIndex:]...)
+	}
+	if c.store != nil {
+		err := c.store.saveSnapshot(clusterSnapshot{
+			Index: req.LastIndex,
+			Term:  req.LastTerm,
+			Ops:   req.Ops,
+		}, entries)
+		if err != nil {
+			c.d.logger.error("failed to persist cluster snapshot", "node", c.id, "error", err)
+			return &res
+		}
+	}
+	c.snapshotIndex, c.snapshotTerm, c.entries = req.LastIndex, req.LastTerm, entries
+	if c.commitIndex < req.LastIndex {
+		c.commitIndex = req.LastIndex
+	}
+	c.lastApplied = req.LastIndex
+	c.d.restore(req.Ops)
+	c.d.logger.info("installed cluster snapshot", "node", c.id, "leader", req.Leader, "index", req.LastIndex)
+	c.applyCommitted()
+	return &res
+}
+
+func (c *cluster) handleVote(req voteRequest) *voteResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if req.Term > c.term {
+		c.stepDown(req.Term)
+	}
+	res := voteResponse{
+		Term: c.term,
+	}
+	lastIndex, lastTerm := c.lastLog()
+	upToDate := req.LastLogTerm > lastTerm || req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex
+	if req.Term == c.term && (c.votedFor == "" || c.votedFor == req.Candidate) && upToDate {
+		c.votedFor = req.Candidate
+		if c.persistState() != nil {
+			c.votedFor = ""
+			return &res
+		}
+		c.resetElection()
+		res.Granted = true
+	}
+	return &res
+}
+
+func (c *cluster) handleAppend(req appendRequest) *appendResponse {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	res := appendResponse{
+		Term: c.term,
+	}
+	if req.Term < c.term {
+		return &res
+	}
+	if req.Term > c.term || c.state != follower {
+		c.stepDown(req.Term)
+	}
+	res.Term = c.term
+	if c.leader != req.Leader {
+		c.d.logger.info("following leader", "node", c.id, "leader", req.Leader, "term", c.term)
+	}
+	c.leader = req.Leader
+	c.resetElection()
+	if req.PrevLogIndex < c.snapshotIndex {
+		skip := c.snapshotIndex - req.PrevLogIndex
+		if skip > uint64(len(req.Entries)) {
+			skip = uint64(len(req.Entries))
+		}
+		req.Entries = req.Entries[skip:]
+		req.PrevLogIndex += skip
+		req.PrevLogTerm = c.termAt(req.PrevLogIndex)
+	}
+	if req.PrevLogIndex > c.lastIndex() {
+		res.LastIndex = c.lastIndex()
+		return &res
+	}
+	if c.termAt(req.PrevLogIndex) != req.PrevLogTerm {
+		res.LastIndex = req.PrevLogIndex - 1
+		return &res
+	}
+	for i, e := range req.Entries {
+		index := req.PrevLogIndex + uint64(i) + 1
+		if index <= c.lastIndex() {
+			if c.entry(index).Term == e.Term {
+				continue
+			}
+			if c.truncate(index) != nil {
+				return &res
+			}
+		}
+		if c.appendEntries(req.Entries[i:]...) != nil {
+			return &res
+		}
+		break
+	}
+	last := req.PrevLogIndex + uint64(len(req.Entries))
+	commit := req.LeaderCommit
+	if commit > last {
+		commit = last
+	}
+	if commit > c.commitIndex {
+		c.commitIndex = commit
+		c.applyCommitted()
+	}
+	res.Success = true
+	res.LastIndex = last
+	return &res
+}
+
+func (c *cluster) handleForward(req forwardRequest) *forwardResponse {
+	c.lock.Lock()
+	isLeader := c.state == leader
+	c.lock.Unlock()
+	if !isLeader {
+		return &forwardResponse{
+			Err: NotClusterLeaderError,
+		}
+	}
+	err := c.propose(req.Op)
+	if err != nil {
+		err = wireError(err)
+	}
+	return &forwardResponse{
+		Err: err,
+	}
+}
+
+func (c *cluster) propose(op registryOp) error {
+	deadline := time.Now().Add(proposalTimeout)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		if c.state == leader {
+			err := c.appendEntries(logEntry{
+				Term: c.term,
+				Op:   op,
+			})
+			if err != nil {
+				c.lock.Unlock()
+				return err
+			}
+			index, term := c.lastLog()
+			c.advanceCommit()
+			c.lock.Unlock()
+			c.replicateAll()
+			return c.waitCommitted(index, term, deadline)
+		}
+		leaderID := c.leader
+		c.lock.Unlock()
+		if p, ok := c.peers[leaderID]; ok {
+			res, err := p.call(clusterRequest{
+				Forward: &forwardRequest{
+					Op: op,
+				},
+			}, time.Until(deadline))
+			if err == nil && res.Forward != nil {
+				if res.Forward.Err == nil {
+					return nil
+				}
+				if res.Forward.Err != NotClusterLeaderError {
+					return res.Forward.Err
+				}
+			}
+		}
+		select {
+		case <-c.d.done.Done():
+			return NotClusterLeaderError
+		case <-time.After(heartbeatInterval):
+		}
+	}
+	return NotClusterLeaderError
+}
+
+func (c *cluster) waitCommitted(index, term uint64, deadline time.Time) error {
+	for {
+		c.lock.Lock()
+		if index <= c.snapshotIndex {
+			c.lock.Unlock()
+			return nil
+		}
+		if c.termAt(index) != term {
+			c.lock.Unlock()
+			return ProposalLostError
+		}
+		if c.commitIndex >= index {
+			c.lock.Unlock()
+			return nil
+		}
+		committed := c.committed
+		c.lock.Unlock()
+		select {
+		case <-committed:
+		case <-time.After(time.Until(deadline)):
+			return ProposalLostError
+		case <-c.d.done.Done():
+			return ProposalLostError
+		}
+	}
+}
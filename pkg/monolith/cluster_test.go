@@ -0,0 +1,388 @@
+package monolith
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCluster(t *testing.T, entries ...logEntry) *cluster {
+	t.Helper()
+	d := NewDispatcher("test")
+	d.SetLogger(NewStdLogger(log.New(io.Discard, "", 0), LevelError))
+	c := newCluster(&d, "a", map[string]string{"b": "127.0.0.1:1", "c": "127.0.0.1:2"})
+	c.entries = entries
+	return c
+}
+
+func putEntry(term uint64, endPoint string) logEntry {
+	return logEntry{
+		Term: term,
+		Op: registryOp{
+			Kind:    putOp,
+			Service: "default/Math",
+			Registration: registration{
+				EndPoint: endPoint,
+			},
+		},
+	}
+}
+
+func TestHandleVote(t *testing.T) {
+	tests := []struct {
+		name     string
+		term     uint64
+		votedFor string
+		entries  []logEntry
+		req      voteRequest
+		granted  bool
+		resTerm  uint64
+	}{
+		{
+			name:    "stale term",
+			term:    3,
+			req:     voteRequest{Term: 2, Candidate: "b"},
+			resTerm: 3,
+		},
+		{
+			name:    "newer term",
+			term:    1,
+			req:     voteRequest{Term: 2, Candidate: "b"},
+			granted: true,
+			resTerm: 2,
+		},
+		{
+			name:     "already voted for another candidate",
+			term:     2,
+			votedFor: "c",
+			req:      voteRequest{Term: 2, Candidate: "b"},
+			resTerm:  2,
+		},
+		{
+			name:     "repeated vote for the same candidate",
+			term:     2,
+			votedFor: "b",
+			req:      voteRequest{Term: 2, Candidate: "b"},
+			granted:  true,
+			resTerm:  2,
+		},
+		{
+			name:    "candidate log has an older last term",
+			term:    2,
+			entries: []logEntry{putEntry(2, "x")},
+			req:     voteRequest{Term: 3, Candidate: "b", LastLogIndex: 5, LastLogTerm: 1},
+			resTerm: 3,
+		},
+		{
+			name:    "candidate log is shorter",
+			term:    2,
+			entries: []logEntry{putEntry(2, "x"), putEntry(2, "y")},
+			req:     voteRequest{Term: 3, Candidate: "b", LastLogIndex: 1, LastLogTerm: 2},
+			resTerm: 3,
+		},
+		{
+			name:    "candidate log is up to date",
+			term:    2,
+			entries: []logEntry{putEntry(2, "x")},
+			req:     voteRequest{Term: 3, Candidate: "b", LastLogIndex: 1, LastLogTerm: 2},
+			granted: true,
+			resTerm: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCluster(t, test.entries...)
+			c.term, c.votedFor = test.term, test.votedFor
+			res := c.handleVote(test.req)
+			if res.Granted != test.granted || res.Term != test.resTerm {
+				t.Fatalf("got granted %v term %v, want %v term %v", res.Granted, res.Term, test.granted, test.resTerm)
+			}
+			if test.granted && c.votedFor != test.req.Candidate {
+				t.Fatalf("voted for %q, want %q", c.votedFor, test.req.Candidate)
+			}
+		})
+	}
+}
+
+func TestHandleAppend(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []logEntry
+		req       appendRequest
+		success   bool
+		lastIndex uint64
+		terms     []uint64
+		commit    uint64
+	}{
+		{
+			name:      "stale term",
+			req:       appendRequest{Term: 0, Leader: "b"},
+			terms:     []uint64{},
+			lastIndex: 0,
+		},
+		{
+			name:      "append to empty log",
+			req:       appendRequest{Term: 1, Leader: "b", Entries: []logEntry{putEntry(1, "x"), putEntry(1, "y")}, LeaderCommit: 1},
+			success:   true,
+			lastIndex: 2,
+			terms:     []uint64{1, 1},
+			commit:    1,
+		},
+		{
+			name:      "missing previous entry",
+			entries:   []logEntry{putEntry(1, "x")},
+			req:       appendRequest{Term: 1, Leader: "b", PrevLogIndex: 3, PrevLogTerm: 1},
+			lastIndex: 1,
+			terms:     []uint64{1},
+		},
+		{
+			name:      "previous term mismatch",
+			entries:   []logEntry{putEntry(1, "x"), putEntry(1, "y")},
+			req:       appendRequest{Term: 2, Leader: "b", PrevLogIndex: 2, PrevLogTerm: 2},
+			lastIndex: 1,
+			terms:     []uint64{1, 1},
+		},
+		{
+			name:      "conflicting entries are truncated",
+			entries:   []logEntry{putEntry(1, "x"), putEntry(1, "y"), putEntry(1, "z")},
+			req:       appendRequest{Term: 2, Leader: "b", PrevLogIndex: 1, PrevLogTerm: 1, Entries: []logEntry{putEntry(2, "w")}, LeaderCommit: 2},
+			success:   true,
+			lastIndex: 2,
+			terms:     []uint64{1, 2},
+			commit:    2,
+		},
+		{
+			name:      "duplicate entries are kept",
+			entries:   []logEntry{putEntry(1, "x"), putEntry(1, "y")},
+			req:       appendRequest{Term: 1, Leader: "b", Entries: []logEntry{putEntry(1, "x")}},
+			success:   true,
+			lastIndex: 1,
+			terms:     []uint64{1, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCluster(t, test.entries...)
+			c.term = 1
+			res := c.handleAppend(test.req)
+			if res.Success != test.success || res.LastIndex != test.lastIndex {
+				t.Fatalf("got success %v last index %v, want %v %v", res.Success, res.LastIndex, test.success, test.lastIndex)
+			}
+			terms := []uint64{}
+			for _, e := range c.entries {
+				terms = append(terms, e.Term)
+			}
+			if fmt.Sprint(terms) != fmt.Sprint(test.terms) {
+				t.Fatalf("got log terms %v, want %v", terms, test.terms)
+			}
+			if c.commitIndex != test.commit || c.lastApplied != test.commit {
+				t.Fatalf("got commit %v applied %v, want %v", c.commitIndex, c.lastApplied, test.commit)
+			}
+		})
+	}
+}
+
+func TestHandleAppendAfterSnapshot(t *testing.T) {
+	c := newTestCluster(t, putEntry(2, "z"))
+	c.term = 2
+	c.snapshotIndex, c.snapshotTerm = 5, 1
+	c.commitIndex, c.lastApplied = 5, 5
+	res := c.handleAppend(appendRequest{
+		Term:         2,
+		Leader:       "b",
+		PrevLogIndex: 4,
+		PrevLogTerm:  1,
+		Entries:      []logEntry{putEntry(1, "y"), putEntry(2, "z"), putEntry(2, "w")},
+		LeaderCommit: 7,
+	})
+	if !res.Success || res.LastIndex != 7 {
+		t.Fatalf("got success %v last index %v, want true 7", res.Success, res.LastIndex)
+	}
+	if c.lastIndex() != 7 || c.termAt(7) != 2 || c.lastApplied != 7 {
+		t.Fatalf("got last index %v term %v applied %v", c.lastIndex(), c.termAt(7), c.lastApplied)
+	}
+}
+
+func TestCompactAndInstallSnapshot(t *testing.T) {
+	leader := newTestCluster(t)
+	s, _, _, _, err := openClusterStore(filepath.Join(t.TempDir(), "raft"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	leader.store = s
+	for i := 0; i < compactionThreshold+1; i++ {
+		leader.entries = append(leader.entries, putEntry(1, fmt.Sprint("127.0.0.1:", i)))
+	}
+	leader.commitIndex = compactionThreshold
+	leader.applyCommitted()
+	if leader.snapshotIndex != compactionThreshold || len(leader.entries) != 1 || leader.termAt(compactionThreshold) != 1 {
+		t.Fatalf("got snapshot index %v with %v entries", leader.snapshotIndex, len(leader.entries))
+	}
+	follower := newTestCluster(t, putEntry(1, "stale"))
+	follower.d.services.put("default/Math", registration{EndPoint: "stale"})
+	res := follower.handleSnapshot(snapshotRequest{
+		Term:      1,
+		Leader:    "a",
+		LastIndex: leader.snapshotIndex,
+		LastTerm:  leader.snapshotTerm,
+		Ops:       leader.d.services.ops(),
+	})
+	if res.Term != 1 || follower.lastApplied != compactionThreshold || follower.lastIndex() != compactionThreshold {
+		t.Fatalf("got term %v applied %v last index %v", res.Term, follower.lastApplied, follower.lastIndex())
+	}
+	if follower.d.services.size() != compactionThreshold {
+		t.Fatalf("got %v registrations, want %v", follower.d.services.size(), compactionThreshold)
+	}
+	if _, ok := follower.d.services.get("default/Math", "", "stale"); ok {
+		t.Fatal("stale registration survived the snapshot")
+	}
+}
+
+func TestClusterStoreRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft")
+	s, _, _, _, err := openClusterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		func() error { return s.saveState(clusterState{Term: 3, VotedFor: "b"}) },
+		func() error { return s.append(1, []logEntry{putEntry(1, "x"), putEntry(1, "y")}) },
+		func() error { return s.append(3, []logEntry{putEntry(2, "z")}) },
+		func() error { return s.rewrite(1, []logEntry{putEntry(1, "x"), putEntry(3, "w")}) },
+		func() error { return s.append(3, []logEntry{putEntry(3, "v")}) },
+		func() error {
+			return s.saveSnapshot(clusterSnapshot{Index: 1, Term: 1, Ops: []registryOp{putEntry(1, "x").Op}},
+				[]logEntry{putEntry(3, "w"), putEntry(3, "v")})
+		},
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %v: %v", i, err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+	_, state, snapshot, entries, err := openClusterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Term != 3 || state.VotedFor != "b" {
+		t.Fatalf("got state %+v", state)
+	}
+	if snapshot.Index != 1 || snapshot.Term != 1 || len(snapshot.Ops) != 1 {
+		t.Fatalf("got snapshot %+v", snapshot)
+	}
+	if len(entries) != 2 || entries[0].Op.Registration.EndPoint != "w" || entries[1].Op.Registration.EndPoint != "v" {
+		t.Fatalf("got entries %+v", entries)
+	}
+}
+
+func freeEndPoints(t *testing.T, n int) []string {
+	t.Helper()
+	var endPoints []string
+	for i := 0; i < n; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		endPoints = append(endPoints, listener.Addr().String())
+		defer listener.Close()
+	}
+	return endPoints
+}
+
+func TestClusterElectsLeaderAndCommits(t *testing.T) {
+	endPoints := freeEndPoints(t, 3)
+	peers := map[string]string{"a": endPoints[0], "b": endPoints[1], "c": endPoints[2]}
+	nodes := make(map[string]*Dispatcher)
+	for id := range peers {
+		d := NewDispatcher(id)
+		d.SetLogger(NewStdLogger(log.New(io.Discard, "", 0), LevelError))
+		if err := d.Persist(filepath.Join(t.TempDir(), id)); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.JoinCluster(id, peers[id], peers); err != nil {
+			t.Fatal(err)
+		}
+		defer d.Shutdown()
+		nodes[id] = &d
+	}
+	var leader string
+	for deadline := time.Now().Add(5 * time.Second); leader == "" && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		leaders := make(map[string]bool)
+		for _, d := range nodes {
+			leaders[d.Leader()] = true
+		}
+		if len(leaders) == 1 && !leaders[""] {
+			for l := range leaders {
+				leader = l
+			}
+		}
+	}
+	if leader == "" {
+		t.Fatal("no leader elected")
+	}
+	for id, d := range nodes {
+		if id == leader {
+			continue
+		}
+		err := d.apply(putEntry(0, "127.0.0.1:9").Op)
+		if err != nil {
+			t.Fatalf("forwarded proposal from %v: %v", id, err)
+		}
+		break
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		committed := 0
+		for _, d := range nodes {
+			if _, ok := d.services.get("default/Math", "", "127.0.0.1:9"); ok {
+				committed++
+			}
+		}
+		if committed == len(nodes) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("registration applied on %v of %v nodes", committed, len(nodes))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestJoinClusterWhileApplying(t *testing.T) {
+	endPoint := freeEndPoints(t, 1)[0]
+	d := NewDispatcher("a")
+	d.SetLogger(NewStdLogger(log.New(io.Discard, "", 0), LevelError))
+	defer d.Shutdown()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = d.apply(putEntry(0, "127.0.0.1:9").Op)
+			_ = d.Leader()
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err := d.JoinCluster("a", endPoint, map[string]string{"a": endPoint})
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Persist(filepath.Join(t.TempDir(), "a")); err != PersistAfterJoinError {
+		t.Fatalf("got %v, want %v", err, PersistAfterJoinError)
+	}
+}
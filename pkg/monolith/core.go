@@ -1,20 +1,101 @@
 package monolith
 
+import (
+	"context"
+	"time"
+)
+
 type Instance struct {
-	Type   string
-	ID     string
-	client *Client
+	Type        string
+	Version     string
+	ID          []byte
+	client      *Client
+	schema      *Schema
+	constraint  VersionConstraint
+	selector    Selector
+	preferences []Selector
+	timeout     time.Duration
+	metadata    map[string]string
+	namespace   string
+	ctx         context.Context
+	exclude     []string
+}
+
+type serviceKey struct {
+	Name    string
+	Version string
+}
+
+type announcement struct {
+	Namespace string
+	Service   string
+	Version   string
+	Address   string
+	Labels    map[string]string
+	Schema    Schema
+	Identity  string
+	Timestamp int64
+	Token     string
+}
+
+type lookup struct {
+	Namespace   string
+	Service     string
+	Version     string
+	Selector    string
+	Preferences []string
+	Exclude     []string
+	Schema      *Schema
+}
+
+type watch struct {
+	Namespace string
+	Service   string
+}
+
+type clientMessage struct {
+	Lookup  *lookup
+	Watch   *watch
+	Unwatch *watch
+}
+
+type watchEvent struct {
+	Service       string
+	Kind          string
+	Registrations []registration
+}
+
+type dispatcherMessage struct {
+	Registration *registration
+	Event        *watchEvent
+}
+
+type registration struct {
+	Version  string
+	EndPoint string
+	Labels   map[string]string
+	Schema   Schema
+	Verified bool
+	Draining bool
 }
 
 type request struct {
-	ID       string
-	Instance Instance
-	Method   string
-	Params   []byte
+	ID        string
+	Instance  Instance
+	Method    string
+	Params    []byte
+	Deadline  time.Time
+	Metadata  map[string]string
+	Caller    string
+	Timestamp int64
+	Token     string
+	Lookup    *lookup
 }
 
 type response struct {
-	ID      string
-	Err     error
-	Results []byte
+	ID       string
+	Err      error
+	Results  []byte
+	sent     int
+	received int
 }
@@ -1,69 +1,257 @@
 package monolith
 
 import (
-	"encoding/gob"
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"io"
-	"log"
 	"net"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 )
 
+const DefaultReconcileTimeout = time.Minute
+
 type Dispatcher struct {
-	name      string
-	services  SyncMap[string, string]
-	listeners []*net.TCPListener
-	wgs       []*sync.WaitGroup
-	logger    *log.Logger
+	name             string
+	services         registry
+	store            *store
+	cluster          *cluster
+	applyLock        *sync.Mutex
+	reconcileTimeout time.Duration
+	connections      SyncMap[string, connection]
+	watchers         SyncMap[string, *watcher]
+	gateways         []*Gateway
+	status           *statusRecorder
+	metrics          Metrics
+	frames           FrameLimits
+	statusServers    []*http.Server
+	statusToken      string
+	exports          exports
+	announceAuth     *AnnounceAuth
+	announceTLS      *tls.Config
+	listeners        []*net.TCPListener
+	wgs              []*sync.WaitGroup
+	done             context.Context
+	stop             context.CancelFunc
+	logger           nodeLogger
 }
 
 func NewDispatcher(name string) Dispatcher {
+	var applyLock sync.Mutex
+	done, stop := context.WithCancel(context.Background())
+	status := newStatusRecorder()
 	return Dispatcher{
-		name:     name,
-		services: NewSyncMap[string, string](),
-		logger:   log.Default(),
+		name:             name,
+		services:         newRegistry(),
+		applyLock:        &applyLock,
+		reconcileTimeout: DefaultReconcileTimeout,
+		connections:      NewSyncMap[string, connection](),
+		watchers:         NewSyncMap[string, *watcher](),
+		exports:          newExports(),
+		status:           status,
+		metrics:          noMetrics{},
+		frames:           DefaultFrameLimits,
+		done:             done,
+		stop:             stop,
+		logger:           newNodeLogger("dispatcher", name, status),
 	}
 }
 
-func (d *Dispatcher) logf(format string, v ...any) {
-	if d.logger == nil {
+func (d *Dispatcher) Name() string {
+	return d.name
+}
+
+func (d *Dispatcher) SetReconcileTimeout(timeout time.Duration) {
+	d.reconcileTimeout = timeout
+}
+
+func (d *Dispatcher) Persist(path string) (err error) {
+	d.applyLock.Lock()
+	defer d.applyLock.Unlock()
+	if d.cluster != nil {
+		return PersistAfterJoinError
+	}
+	s, ops, err := openStore(path)
+	if err != nil {
+		return
+	}
+	for _, op := range ops {
+		if !strings.Contains(op.Service, "/") {
+			op.Service = qualify(DefaultNamespace, op.Service)
+		}
+		op.Registration.Verified = false
+		d.services.apply(op)
+	}
+	err = s.compact(d.services.ops())
+	if err != nil {
 		return
 	}
-	message := fmt.Sprintf(format, v...)
-	d.logger.Printf("Dispatcher '%v': %v\n", d.name, message)
+	d.store = s
+	restored := len(d.services.ops())
+	d.metrics.Gauge("monolith_dispatcher_registrations", nil, float64(restored))
+	d.logger.info("restored unverified registrations", "count", restored, "path", path)
+	if restored != 0 {
+		go d.reconcile()
+	}
+	return
 }
 
-func (d *Dispatcher) log(v ...any) {
-	if d.logger == nil {
+func (d *Dispatcher) reconcile() {
+	select {
+	case <-d.done.Done():
+		return
+	case <-time.After(d.reconcileTimeout):
+	}
+	if d.clusterNode() != nil {
+		// The cluster log replaced the local store, its registrations are not restored ones.
 		return
 	}
-	message := fmt.Sprint(v...)
-	d.logger.Printf("Dispatcher '%v': %v\n", d.name, message)
+	for _, op := range d.services.ops() {
+		if op.Registration.Verified {
+			continue
+		}
+		d.logger.info("removing unverified registration",
+			"service", op.Service, "version", op.Registration.Version, "endpoint", op.Registration.EndPoint)
+		op.Kind = removeOp
+		err := d.apply(op)
+		if err != nil {
+			d.logger.error("failed to remove unverified registration", "service", op.Service,
+				"endpoint", op.Registration.EndPoint, "error", err)
+		}
+	}
 }
 
-func (d *Dispatcher) SetLogger(logger *log.Logger) {
-	d.logger = logger
+func (d *Dispatcher) clusterNode() *cluster {
+	d.applyLock.Lock()
+	defer d.applyLock.Unlock()
+	return d.cluster
 }
 
-func (d *Dispatcher) Name() string {
-	return d.name
+func (d *Dispatcher) apply(op registryOp) error {
+	d.applyLock.Lock()
+	c := d.cluster
+	if c == nil {
+		defer d.applyLock.Unlock()
+		d.commitLocked(op)
+		return nil
+	}
+	d.applyLock.Unlock()
+	return c.propose(op)
+}
+
+func (d *Dispatcher) restore(ops []registryOp) {
+	d.applyLock.Lock()
+	defer d.applyLock.Unlock()
+	for _, op := range d.services.reset(ops) {
+		d.notify(op)
+	}
+	d.metrics.Gauge("monolith_dispatcher_registrations", nil, float64(d.services.size()))
+}
+
+func (d *Dispatcher) commit(op registryOp) {
+	d.applyLock.Lock()
+	defer d.applyLock.Unlock()
+	d.commitLocked(op)
+}
+
+func (d *Dispatcher) commitLocked(op registryOp) {
+	d.services.apply(op)
+	d.metrics.Gauge("monolith_dispatcher_registrations", nil, float64(d.services.size()))
+	d.notify(op)
+	if d.store == nil {
+		return
+	}
+	compact, err := d.store.append(op)
+	if err != nil {
+		d.logger.error("failed to persist registry change", "error", err)
+		return
+	}
+	if compact {
+		err = d.store.compact(d.services.ops())
+		if err != nil {
+			d.logger.error("failed to compact registry store", "error", err)
+		}
+	}
+}
+
+func connectionKey(conn net.Conn) string {
+	return conn.LocalAddr().String() + "-" + conn.RemoteAddr().String()
+}
+
+type connection struct {
+	conn  net.Conn
+	kind  string
+	since time.Time
+}
+
+func (d *Dispatcher) track(conn net.Conn, kind string) func() {
+	untrack := trackConnection(d.connections, conn, kind)
+	d.countConnections(kind)
+	return func() {
+		untrack()
+		d.countConnections(kind)
+	}
+}
+
+func trackConnection(connections SyncMap[string, connection], conn net.Conn, kind string) func() {
+	key := connectionKey(conn)
+	connections.put(key, connection{
+		conn:  conn,
+		kind:  kind,
+		since: time.Now(),
+	})
+	return func() {
+		connections.delete(key)
+	}
 }
 
 func (d *Dispatcher) Stop() {
-	d.log("stopping...")
+	d.logger.info("stopping")
+	d.stop()
 	for _, listener := range d.listeners {
 		err := listener.Close()
 		if err != nil {
-			d.log(err)
+			d.logger.warn("failed to close listener", "error", err)
+		}
+	}
+	for _, server := range d.statusServers {
+		err := server.Close()
+		if err != nil {
+			d.logger.warn("failed to close status server", "error", err)
 		}
 	}
+	for _, c := range d.connections.values() {
+		_ = c.conn.Close()
+	}
+	for _, g := range d.gateways {
+		g.Stop()
+		g.client.Close()
+	}
 }
 
 func (d *Dispatcher) Wait() {
 	for _, wg := range d.wgs {
 		wg.Wait()
 	}
-	d.log("graceful shutdown complete.")
+	for _, g := range d.gateways {
+		g.Wait()
+	}
+	if d.store != nil {
+		err := d.store.close()
+		if err != nil {
+			d.logger.error("failed to close registry store", "error", err)
+		}
+	}
+	if c := d.clusterNode(); c != nil && c.store != nil {
+		err := c.store.close()
+		if err != nil {
+			d.logger.error("failed to close cluster store", "error", err)
+		}
+	}
+	d.logger.info("graceful shutdown complete")
 }
 
 func (d *Dispatcher) Shutdown() {
@@ -80,24 +268,30 @@ func (d *Dispatcher) ListenAnnounces(endPoint string) (err error) {
 	if err != nil {
 		return
 	}
-	d.log("started listening service announces on ", endPoint)
+	d.logger.info("started listening service announces", "endpoint", endPoint)
 	d.listeners = append(d.listeners, listener)
 	var wg sync.WaitGroup
 	d.wgs = append(d.wgs, &wg)
 	go func() {
-		defer d.log("stopped listening service announces on ", endPoint)
+		defer d.logger.info("stopped listening service announces", "endpoint", endPoint)
 		for {
 			conn, err := listener.AcceptTCP()
 			if err != nil {
-				d.log(err)
+				if !errors.Is(err, net.ErrClosed) {
+					d.logger.error("failed to accept connection", "error", err)
+				}
 				return
 			}
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				err := d.addServices(conn)
-				if err != nil && err != io.EOF {
-					d.log(err)
+				var c net.Conn = conn
+				if d.announceTLS != nil {
+					c = tls.Server(conn, d.announceTLS)
+				}
+				err := d.addServices(c)
+				if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					d.logger.warn("server connection failed", "peer", conn.RemoteAddr(), "error", err)
 				}
 			}()
 		}
@@ -106,30 +300,109 @@ func (d *Dispatcher) ListenAnnounces(endPoint string) (err error) {
 }
 
 func (d *Dispatcher) addServices(conn net.Conn) (err error) {
+	defer d.track(conn, "server")()
 	defer func() {
 		closeErr := conn.Close()
-		if closeErr != nil {
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
 			if err == nil {
 				err = closeErr
 			} else {
-				d.log(closeErr)
+				d.logger.warn("failed to close connection", "error", closeErr)
 			}
 		}
 	}()
 	remote := conn.RemoteAddr().String()
-	d.log("server connected from address ", remote)
-	decoder := gob.NewDecoder(conn)
+	tlsIdentity, err := peerIdentity(conn)
+	if err != nil {
+		return
+	}
+	d.logger.info("server connected", "peer", remote)
+	decoder := newFrameDecoder(conn, d.frames)
+	encoder := newFrameEncoder(conn, d.frames)
 	for {
-		var service string
-		err = decoder.Decode(&service)
+		var a announcement
+		err = decoder.Decode(&a)
 		if err != nil {
+			if errors.Is(err, FrameTooLargeError) {
+				_ = encoder.Encode(announceAck{
+					Err: err,
+				})
+			}
 			return
 		}
-		d.services.put(service, remote)
-		d.logf("server %v announced service '%v'", remote, service)
+		ack := announceAck{
+			Service: a.Service,
+			Version: a.Version,
+		}
+		endPoint := advertisedEndPoint(a.Address, remote)
+		version, versionErr := canonicalVersion(a.Version)
+		identity, authErr := d.authorize(a, tlsIdentity)
+		switch {
+		case !validNamespace(a.Namespace):
+			ack.Err = InvalidNamespaceError.withDetail("'%v'", a.Namespace)
+		case versionErr != nil:
+			ack.Err = wireError(versionErr)
+		case authErr != nil:
+			ack.Err = authErr
+		}
+		name := qualify(a.Namespace, a.Service)
+		if ack.Err == nil {
+			reg := registration{
+				Version:  version,
+				EndPoint: endPoint,
+				Labels:   a.Labels,
+				Schema:   a.Schema,
+				Verified: true,
+			}
+			if existing, ok := d.services.get(name, version, endPoint); ok {
+				reg.Draining = existing.Draining
+			}
+			d.services.touch(endPoint)
+			applyErr := d.apply(registryOp{
+				Kind:         putOp,
+				Service:      name,
+				Registration: reg,
+			})
+			if applyErr != nil {
+				ack.Err = wireError(applyErr)
+			}
+		}
+		err = encoder.Encode(ack)
+		if err != nil {
+			return
+		}
+		if ack.Err != nil {
+			d.logger.warn("rejected announce", "service", name,
+				"version", a.Version, "peer", remote, "error", ack.Err)
+			continue
+		}
+		fields := []any{"peer", remote, "service", name, "version", a.Version,
+			"endpoint", endPoint, "labels", a.Labels, "schema", a.Schema.Hash}
+		if identity != "" {
+			fields = append(fields, "identity", identity)
+		}
+		d.logger.info("server announced service", fields...)
 	}
 }
 
+func advertisedEndPoint(address, remote string) string {
+	if address == "" {
+		return remote
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return address
+	}
+	remoteHost, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return address
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
+
 func (d *Dispatcher) Serve(endPoint string) (err error) {
 	local, err := net.ResolveTCPAddr("tcp", endPoint)
 	if err != nil {
@@ -139,28 +412,30 @@ func (d *Dispatcher) Serve(endPoint string) (err error) {
 	if err != nil {
 		return
 	}
-	d.log("started listening client connections on ", endPoint)
+	d.logger.info("started listening client connections", "endpoint", endPoint)
 	d.listeners = append(d.listeners, listener)
 	var wg sync.WaitGroup
 	d.wgs = append(d.wgs, &wg)
 	go func() {
-		defer d.log("stopped listening client connections on ", endPoint)
+		defer d.logger.info("stopped listening client connections", "endpoint", endPoint)
 		for {
 			conn, err := listener.AcceptTCP()
 			if err != nil {
-				d.log(err)
+				if !errors.Is(err, net.ErrClosed) {
+					d.logger.error("failed to accept connection", "error", err)
+				}
 				return
 			}
 			remote := conn.RemoteAddr().String()
-			d.log("client connected from address ", remote)
+			d.logger.debug("client connected", "peer", remote)
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
 				err := d.respond(conn)
-				if err != nil && err != io.EOF {
-					d.log(err)
+				if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					d.logger.warn("client connection failed", "peer", remote, "error", err)
 				} else {
-					d.log("client ", remote, " disconnected")
+					d.logger.debug("client disconnected", "peer", remote)
 				}
 			}()
 		}
@@ -169,31 +444,54 @@ func (d *Dispatcher) Serve(endPoint string) (err error) {
 }
 
 func (d *Dispatcher) respond(conn net.Conn) (err error) {
+	defer d.track(conn, "client")()
 	defer func() {
 		closeErr := conn.Close()
-		if closeErr != nil {
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
 			if err == nil {
 				err = closeErr
 			} else {
-				d.log(closeErr)
+				d.logger.warn("failed to close connection", "error", closeErr)
 			}
 		}
 	}()
 	remote := conn.RemoteAddr().String()
-	decoder := gob.NewDecoder(conn)
-	encoder := gob.NewEncoder(conn)
-	for {
-		var service string
-		err = decoder.Decode(&service)
+	w := newWatcher(conn)
+	key := connectionKey(conn)
+	d.watchers.put(key, w)
+	defer d.watchers.delete(key)
+	defer close(w.done)
+	go func() {
+		err := w.write(newFrameEncoder(conn, d.frames))
 		if err != nil {
-			return
+			_ = conn.Close()
 		}
-		d.logf("client %v requested service '%v'", remote, service)
-		endPoint, _ := d.services.get(service)
-		err = encoder.Encode(endPoint)
+	}()
+	decoder := newFrameDecoder(conn, d.frames)
+	for {
+		var m clientMessage
+		err = decoder.Decode(&m)
 		if err != nil {
 			return
 		}
-		d.logf("responded to client %v: service '%v' has address %v", remote, service, endPoint)
+		switch {
+		case m.Lookup != nil:
+			l := *m.Lookup
+			start := time.Now()
+			r, found := d.resolve(l)
+			d.countLookup(l.Namespace, l.Service, found)
+			w.send(dispatcherMessage{
+				Registration: &r,
+			})
+			d.logger.request("resolved service", "peer", remote, "service", qualify(l.Namespace, l.Service),
+				"version", l.Version, "selector", l.Selector, "preferences", l.Preferences,
+				"resolved_version", r.Version, "endpoint", r.EndPoint, "duration", time.Since(start))
+		case m.Watch != nil:
+			d.subscribe(w, *m.Watch)
+			d.logger.debug("client started watching service", "peer", remote, "service", qualify(m.Watch.Namespace, m.Watch.Service))
+		case m.Unwatch != nil:
+			w.unsubscribe(*m.Unwatch)
+			d.logger.debug("client stopped watching service", "peer", remote, "service", qualify(m.Unwatch.Namespace, m.Unwatch.Service))
+		}
 	}
 }
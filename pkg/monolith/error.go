@@ -1,15 +1,29 @@
 package monolith
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
 )
 
 type Error struct {
 	Message string
+	Detail  string
 }
 
 func (e Error) Error() string {
-	return e.Message
+	if e.Detail == "" {
+		return e.Message
+	}
+	return e.Message + ": " + e.Detail
+}
+
+// Is lets a detailed error match the error it adds details to, even after gob.
+func (e Error) Is(target error) bool {
+	t, ok := target.(Error)
+	return ok && t.Detail == "" && t.Message == e.Message
 }
 
 func NewError(message string) Error {
@@ -18,11 +32,123 @@ func NewError(message string) Error {
 	}
 }
 
+func wireError(err error) error {
+	// Other errors may not be registered with gob, but ours keep their details.
+	if e, ok := err.(Error); ok {
+		return e
+	}
+	return NewError(err.Error())
+}
+
+func (e Error) withDetail(format string, v ...any) Error {
+	e.Detail = fmt.Sprintf(format, v...)
+	return e
+}
+
 var UnregisteredTypeError = NewError("unregistered type request received")
 var MethodNotFoundError = NewError("method not found")
 var RequestNotFoundError = NewError("request not found")
 var ServiceNotFoundError = NewError("service not found")
 var ProxyTypeNotFoundError = NewError("proxy type not found")
+var DependencyNotFoundError = NewError("dependency not found")
+var MissingIDError = NewError("instance ID is missing")
+var InvalidIDError = NewError("invalid instance ID")
+var IncompatibleSchemaError = NewError("incompatible schema for service")
+var NoDispatcherError = NewError("no dispatcher endpoint given")
+var DeadlineExceededError = NewError("deadline exceeded")
+var ConnectionClosedError = NewError("connection closed before a response was received")
+var NotServingError = NewError("server is not serving")
+var UnauthenticatedAnnounceError = NewError("announce is not authenticated")
+var UnauthorizedAnnounceError = NewError("announce is not authorized")
+var UnauthenticatedAdminError = NewError("admin command is not authenticated")
+var UnauthorizedAdminError = NewError("admin command is not authorized")
+var UnauthenticatedCallerError = NewError("caller is not authenticated")
+var InsecureAdminError = NewError("admin endpoint without announce auth must listen on a loopback address, call SetAnnounceAuth first")
+var InsecureStatusError = NewError("status page without a token must listen on a loopback address, call SetStatusToken first")
+var InvalidNamespaceError = NewError("invalid namespace")
+var MissingEndPointError = NewError("endpoint address and service are required")
+var UnknownCommandError = NewError("unknown admin command")
+var NoResolverError = NewError("no resolver configured, pass dispatcher endpoints or call SetResolver")
+var EmptyEndPointsFileError = NewError("endpoints file is empty")
+var WatchTimeoutError = NewError("timed out waiting for dispatcher to send service endpoints")
+var UnexpectedMessageError = NewError("unexpected message received")
+var NotClusterLeaderError = NewError("no cluster leader available")
+var ProposalLostError = NewError("registry change was not committed by the cluster")
+var PersistAfterJoinError = NewError("persist must be called before joining a cluster")
+var InvalidTraceParentError = NewError("invalid traceparent")
+var ResourceExhaustedError = NewError("server is at capacity, retry on another endpoint")
+var FrameTooLargeError = NewError("message exceeds the maximum frame size")
+var ParamsTooLargeError = NewError("request params exceed the maximum size")
+var InvalidFrameError = NewError("invalid frame")
+var CorruptStoreError = NewError("store file is corrupt")
+var RateLimitedError = NewError("rate limit exceeded")
+var QuotaExceededError = NewError("daily quota exceeded")
+var InvalidRateLimitError = NewError("rate limit needs a positive rate or daily quota")
+var NoAdvertisedAddressError = NewError("no advertised address, call Serve or SetAdvertisedAddress first")
+
+var errorCodes = map[Error]string{
+	UnregisteredTypeError:        "Unimplemented",
+	MethodNotFoundError:          "Unimplemented",
+	ServiceNotFoundError:         "NotFound",
+	ProxyTypeNotFoundError:       "NotFound",
+	DependencyNotFoundError:      "NotFound",
+	MissingIDError:               "InvalidArgument",
+	InvalidNamespaceError:        "InvalidArgument",
+	MissingEndPointError:         "InvalidArgument",
+	UnknownCommandError:          "InvalidArgument",
+	InvalidIDError:               "InvalidArgument",
+	IncompatibleSchemaError:      "FailedPrecondition",
+	NoDispatcherError:            "FailedPrecondition",
+	NoResolverError:              "FailedPrecondition",
+	EmptyEndPointsFileError:      "InvalidArgument",
+	PersistAfterJoinError:        "FailedPrecondition",
+	DeadlineExceededError:        "DeadlineExceeded",
+	WatchTimeoutError:            "DeadlineExceeded",
+	ConnectionClosedError:        "Unavailable",
+	NotServingError:              "Unavailable",
+	ResourceExhaustedError:       "ResourceExhausted",
+	FrameTooLargeError:           "ResourceExhausted",
+	ParamsTooLargeError:          "ResourceExhausted",
+	InvalidFrameError:            "InvalidArgument",
+	CorruptStoreError:            "DataLoss",
+	RateLimitedError:             "ResourceExhausted",
+	QuotaExceededError:           "ResourceExhausted",
+	InvalidRateLimitError:        "InvalidArgument",
+	NotClusterLeaderError:        "Unavailable",
+	UnauthenticatedAnnounceError: "Unauthenticated",
+	UnauthorizedAnnounceError:    "PermissionDenied",
+	UnauthenticatedAdminError:    "Unauthenticated",
+	UnauthorizedAdminError:       "PermissionDenied",
+	UnauthenticatedCallerError:   "Unauthenticated",
+	InsecureAdminError:           "FailedPrecondition",
+	InsecureStatusError:          "FailedPrecondition",
+}
+
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return "OK"
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "DeadlineExceeded"
+	}
+	var e Error
+	if errors.As(err, &e) {
+		if code, ok := errorCodes[NewError(e.Message)]; ok {
+			return code
+		}
+		return "Unknown"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "DeadlineExceeded"
+		}
+		return "Unavailable"
+	}
+	return "Unknown"
+}
 
 func init() {
 	gob.Register(NewError(""))
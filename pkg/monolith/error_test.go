@@ -0,0 +1,69 @@
+package monolith
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{err: nil, code: "OK"},
+		{err: ServiceNotFoundError, code: "NotFound"},
+		{err: fmt.Errorf("call: %w", RateLimitedError), code: "ResourceExhausted"},
+		{err: NewError(ServiceNotFoundError.Message), code: "NotFound"},
+		{err: UnauthenticatedAnnounceError.withDetail("no credentials"), code: "Unauthenticated"},
+		{err: UnauthorizedAdminError.withDetail("identity %q, service '%v'", "ops", "default/Math"), code: "PermissionDenied"},
+		{err: Schema{Service: "Math", ID: "int", Hash: "a"}.CheckCompatible(Schema{ID: "string", Hash: "b"}), code: "FailedPrecondition"},
+		{err: InvalidNamespaceError.withDetail("'%v'", "a/b"), code: "InvalidArgument"},
+		{err: NewError(InvalidNamespaceError.Message + ": 'a/b'"), code: "Unknown"},
+		{err: NewError("service not found anywhere"), code: "Unknown"},
+		{err: context.Canceled, code: "Canceled"},
+		{err: errors.New("boom"), code: "Unknown"},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.err), func(t *testing.T) {
+			if code := ErrorCode(test.err); code != test.code {
+				t.Fatalf("got %v, want %v", code, test.code)
+			}
+		})
+	}
+}
+
+func TestErrorSurvivesGob(t *testing.T) {
+	tests := []struct {
+		err  error
+		base Error
+	}{
+		{err: ServiceNotFoundError, base: ServiceNotFoundError},
+		{err: UnauthenticatedCallerError.withDetail("no credentials for caller %q", "web"), base: UnauthenticatedCallerError},
+		{err: CorruptStoreError.withDetail("%v line %v", "registry", 3), base: CorruptStoreError},
+	}
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := gob.NewEncoder(&buffer).Encode(response{Err: wireError(test.err)}); err != nil {
+				t.Fatal(err)
+			}
+			var res response
+			if err := gob.NewDecoder(&buffer).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Err.Error() != test.err.Error() {
+				t.Fatalf("got %q, want %q", res.Err, test.err)
+			}
+			if !errors.Is(res.Err, test.base) || ErrorCode(res.Err) != ErrorCode(test.base) {
+				t.Fatalf("%v lost its identity: code %v", res.Err, ErrorCode(res.Err))
+			}
+			if errors.Is(res.Err, MethodNotFoundError) {
+				t.Fatalf("%v matches an unrelated error", res.Err)
+			}
+		})
+	}
+}
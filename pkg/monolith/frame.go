@@ -0,0 +1,207 @@
+package monolith
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	frameHeaderSize = 4
+	frameNewStream  = 1 << 31
+	frameRetain     = 64 << 10
+)
+
+type FrameLimits struct {
+	MaxFrameSize  int
+	MaxParamsSize int
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+}
+
+var DefaultFrameLimits = FrameLimits{
+	MaxFrameSize:  16 << 20,
+	MaxParamsSize: 8 << 20,
+	ReadTimeout:   30 * time.Second,
+	WriteTimeout:  30 * time.Second,
+}
+
+func (l FrameLimits) withoutTimeouts() FrameLimits {
+	l.ReadTimeout = 0
+	l.WriteTimeout = 0
+	return l
+}
+
+func (l FrameLimits) checkParams(params []byte) error {
+	if l.MaxParamsSize > 0 && len(params) > l.MaxParamsSize {
+		return ParamsTooLargeError
+	}
+	return nil
+}
+
+type frameEncoder struct {
+	conn   net.Conn
+	limits FrameLimits
+	buffer bytes.Buffer
+	stream *gob.Encoder
+	lock   sync.Mutex
+}
+
+func newFrameEncoder(conn net.Conn, limits FrameLimits) *frameEncoder {
+	return &frameEncoder{
+		conn:   conn,
+		limits: limits,
+	}
+}
+
+func (e *frameEncoder) Encode(v any) error {
+	_, err := e.encodeSized(v)
+	return err
+}
+
+func (e *frameEncoder) encodeSized(v any) (size int, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	// The connection carries a single gob stream, so type descriptors are only
+	// sent once. A failed encode can leave descriptors the peer never receives,
+	// so the next frame starts a new stream.
+	var header uint32
+	if e.stream == nil {
+		e.stream = gob.NewEncoder(&e.buffer)
+		header = frameNewStream
+	}
+	if e.buffer.Cap() > frameRetain {
+		e.buffer = bytes.Buffer{}
+	}
+	e.buffer.Reset()
+	e.buffer.Write(make([]byte, frameHeaderSize))
+	err = e.stream.Encode(v)
+	if err != nil {
+		e.stream = nil
+		return
+	}
+	frame := e.buffer.Bytes()
+	size = len(frame) - frameHeaderSize
+	if int64(size) >= frameNewStream || e.limits.MaxFrameSize > 0 && size > e.limits.MaxFrameSize {
+		e.stream = nil
+		return 0, FrameTooLargeError
+	}
+	binary.BigEndian.PutUint32(frame, uint32(size)|header)
+	if e.limits.WriteTimeout > 0 {
+		err = e.conn.SetWriteDeadline(time.Now().Add(e.limits.WriteTimeout))
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = e.conn.SetWriteDeadline(time.Time{})
+		}()
+	}
+	_, err = e.conn.Write(frame)
+	return len(frame), err
+}
+
+type frameDecoder struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	limits FrameLimits
+	buffer bytes.Buffer
+	stream *gob.Decoder
+}
+
+func newFrameDecoder(conn net.Conn, limits FrameLimits) *frameDecoder {
+	return &frameDecoder{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		limits: limits,
+	}
+}
+
+func (d *frameDecoder) Decode(v any) error {
+	_, err := d.decodeSized(v)
+	return err
+}
+
+func (d *frameDecoder) decodeSized(v any) (size int, err error) {
+	var header [frameHeaderSize]byte
+	_, err = io.ReadFull(d.reader, header[:])
+	if err != nil {
+		return
+	}
+	payload := int64(binary.BigEndian.Uint32(header[:]) &^ frameNewStream)
+	if d.limits.MaxFrameSize > 0 && payload > int64(d.limits.MaxFrameSize) {
+		return 0, FrameTooLargeError
+	}
+	if binary.BigEndian.Uint32(header[:])&frameNewStream != 0 {
+		d.stream = gob.NewDecoder(&d.buffer)
+	}
+	if d.stream == nil {
+		return 0, InvalidFrameError
+	}
+	if d.limits.ReadTimeout > 0 {
+		err = d.conn.SetReadDeadline(time.Now().Add(d.limits.ReadTimeout))
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = d.conn.SetReadDeadline(time.Time{})
+		}()
+	}
+	if d.buffer.Cap() > frameRetain {
+		d.buffer = bytes.Buffer{}
+	}
+	d.buffer.Reset()
+	// The buffer grows with the data that arrives instead of trusting the header.
+	n, err := d.buffer.ReadFrom(io.LimitReader(d.reader, payload))
+	if err == nil && n < payload {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return
+	}
+	err = d.stream.Decode(v)
+	if err == nil && d.buffer.Len() != 0 {
+		err = InvalidFrameError
+	}
+	return frameHeaderSize + int(payload), err
+}
+
+func (c *Client) SetFrameLimits(limits FrameLimits) {
+	c.frames = limits
+}
+
+func (s *Server) SetFrameLimits(limits FrameLimits) {
+	s.frames = limits
+}
+
+func (d *Dispatcher) SetFrameLimits(limits FrameLimits) {
+	d.frames = limits
+}
+
+func (g *Gateway) SetFrameLimits(limits FrameLimits) {
+	g.frames = limits
+}
+
+func rejectFrame(encoder *frameEncoder, err error) {
+	if errors.Is(err, FrameTooLargeError) {
+		_ = encoder.Encode(response{
+			Err: FrameTooLargeError,
+		})
+	}
+}
+
+func encodeResponse(encoder *frameEncoder, res response) (size int, err error) {
+	size, err = encoder.encodeSized(res)
+	if errors.Is(err, FrameTooLargeError) {
+		return encoder.encodeSized(response{
+			ID:  res.ID,
+			Err: FrameTooLargeError,
+		})
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package monolith
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func rawFrame(header uint32, payload []byte) []byte {
+	frame := binary.BigEndian.AppendUint32(nil, header)
+	return append(frame, payload...)
+}
+
+func TestFrameDecoderRejects(t *testing.T) {
+	var value bytes.Buffer
+	err := gob.NewEncoder(&value).Encode(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := value.Bytes()
+	tests := []struct {
+		name string
+		wire []byte
+		err  error
+	}{
+		{
+			name: "valid frame",
+			wire: rawFrame(frameNewStream|uint32(len(valid)), valid),
+		},
+		{
+			name: "oversize frame",
+			wire: rawFrame(1<<20, nil),
+			err:  FrameTooLargeError,
+		},
+		{
+			name: "oversize frame starting a stream",
+			wire: rawFrame(frameNewStream|1<<20, nil),
+			err:  FrameTooLargeError,
+		},
+		{
+			name: "frame outside a stream",
+			wire: rawFrame(uint32(len(valid)), valid),
+			err:  InvalidFrameError,
+		},
+		{
+			name: "truncated frame",
+			wire: rawFrame(frameNewStream|uint32(len(valid)+10), valid),
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "trailing bytes",
+			wire: rawFrame(frameNewStream|uint32(len(valid)+1), append(valid[:len(valid):len(valid)], 0)),
+			err:  InvalidFrameError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				_, _ = client.Write(test.wire)
+				_ = client.Close()
+			}()
+			decoder := newFrameDecoder(server, FrameLimits{MaxFrameSize: 1024})
+			var v int
+			_, err := decoder.decodeSized(&v)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if err == nil && v != 42 {
+				t.Fatalf("got %v, want 42", v)
+			}
+		})
+	}
+}
+
+func TestFrameEncoderStream(t *testing.T) {
+	type message struct {
+		Text string
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	encoder := newFrameEncoder(client, FrameLimits{MaxFrameSize: 256})
+	decoder := newFrameDecoder(server, FrameLimits{MaxFrameSize: 256})
+	received := make(chan string)
+	go func() {
+		defer close(received)
+		for {
+			var m message
+			if decoder.Decode(&m) != nil {
+				return
+			}
+			received <- m.Text
+		}
+	}()
+	steps := []struct {
+		text    string
+		err     error
+		smaller bool
+	}{
+		{text: "first"},
+		{text: "second", smaller: true},
+		{text: strings.Repeat("x", 512), err: FrameTooLargeError},
+		{text: "after an oversize frame"},
+	}
+	previous := 0
+	for _, step := range steps {
+		size, err := encoder.encodeSized(message{Text: step.text})
+		if !errors.Is(err, step.err) {
+			t.Fatalf("%q: got %v, want %v", step.text, err, step.err)
+		}
+		if err != nil {
+			continue
+		}
+		if step.smaller && size >= previous {
+			t.Fatalf("%q: frame of %v bytes resent type descriptors, first was %v", step.text, size, previous)
+		}
+		previous = size
+		if text := <-received; text != step.text {
+			t.Fatalf("got %q, want %q", text, step.text)
+		}
+	}
+}
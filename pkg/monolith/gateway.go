@@ -0,0 +1,249 @@
+package monolith
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const gatewayRouteKey = "@gateway"
+
+type Gateway struct {
+	name        string
+	client      *Client
+	connections SyncMap[string, net.Conn]
+	frames      FrameLimits
+	listeners   []*net.TCPListener
+	wgs         []*sync.WaitGroup
+	done        context.Context
+	stop        context.CancelFunc
+	logger      nodeLogger
+}
+
+func NewGateway(name string, client *Client) Gateway {
+	done, stop := context.WithCancel(context.Background())
+	return Gateway{
+		name:        name,
+		client:      client,
+		connections: NewSyncMap[string, net.Conn](),
+		frames:      DefaultFrameLimits,
+		done:        done,
+		stop:        stop,
+		logger:      newNodeLogger("gateway", name, nil),
+	}
+}
+
+func (g *Gateway) Serve(endPoint string) (err error) {
+	local, err := net.ResolveTCPAddr("tcp", endPoint)
+	if err != nil {
+		return
+	}
+	listener, err := net.ListenTCP("tcp", local)
+	if err != nil {
+		return
+	}
+	g.logger.info("started listening client connections", "endpoint", listener.Addr())
+	g.listeners = append(g.listeners, listener)
+	var wg sync.WaitGroup
+	g.wgs = append(g.wgs, &wg)
+	go func() {
+		defer g.logger.info("stopped listening client connections", "endpoint", endPoint)
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					g.logger.error("failed to accept connection", "error", err)
+				}
+				return
+			}
+			remote := conn.RemoteAddr().String()
+			g.logger.debug("client connected", "peer", remote)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := g.forward(conn, &wg)
+				if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					g.logger.warn("client connection failed", "peer", remote, "error", err)
+				} else {
+					g.logger.debug("client disconnected", "peer", remote)
+				}
+			}()
+		}
+	}()
+	return
+}
+
+func (g *Gateway) Addr() net.Addr {
+	if len(g.listeners) == 0 {
+		return nil
+	}
+	return g.listeners[0].Addr()
+}
+
+func (g *Gateway) Stop() {
+	g.logger.info("stopping")
+	g.stop()
+	for _, listener := range g.listeners {
+		err := listener.Close()
+		if err != nil {
+			g.logger.warn("failed to close listener", "error", err)
+		}
+	}
+	for _, conn := range g.connections.values() {
+		_ = conn.Close()
+	}
+}
+
+func (g *Gateway) Wait() {
+	for _, wg := range g.wgs {
+		wg.Wait()
+	}
+	g.logger.info("graceful shutdown complete")
+}
+
+func (g *Gateway) Shutdown() {
+	g.Stop()
+	g.Wait()
+}
+
+func (g *Gateway) forward(conn net.Conn, wg *sync.WaitGroup) (err error) {
+	key := connectionKey(conn)
+	g.connections.put(key, conn)
+	defer g.connections.delete(key)
+	defer func() {
+		closeErr := conn.Close()
+		if closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			if err == nil {
+				err = closeErr
+			} else {
+				g.logger.warn("failed to close connection", "error", closeErr)
+			}
+		}
+	}()
+	remote := conn.RemoteAddr().String()
+	decoder := newFrameDecoder(conn, g.frames)
+	encoder := newFrameEncoder(conn, g.frames)
+	var encoderLock sync.Mutex
+	for {
+		var req request
+		err = decoder.Decode(&req)
+		if err != nil {
+			rejectFrame(encoder, err)
+			return
+		}
+		wg.Add(1)
+		go func(req request) {
+			defer wg.Done()
+			start := time.Now()
+			res := g.relay(req)
+			if res.Err != nil {
+				res.Err = wireError(res.Err)
+			}
+			encoderLock.Lock()
+			_, err := encodeResponse(encoder, res)
+			encoderLock.Unlock()
+			if err != nil {
+				g.logger.error("failed to send response", "service", req.Instance.Type, "method", req.Method,
+					"request_id", req.ID, "peer", remote, "error", err)
+			} else {
+				g.logger.request("relayed request", "service", req.Instance.Type, "method", req.Method,
+					"request_id", req.ID, "peer", remote, "duration", time.Since(start), "error", res.Err)
+			}
+		}(req)
+	}
+}
+
+func (g *Gateway) relay(req request) (res response) {
+	if !req.Deadline.IsZero() && time.Now().After(req.Deadline) {
+		return response{
+			ID:  req.ID,
+			Err: DeadlineExceededError,
+		}
+	}
+	l := lookup{
+		Service: req.Instance.Type,
+		Version: req.Instance.Version,
+	}
+	if req.Lookup != nil {
+		l = *req.Lookup
+	}
+	req.Lookup = nil
+	i, err := g.instance(l)
+	if err != nil {
+		return response{
+			ID:  req.ID,
+			Err: err,
+		}
+	}
+	res = i.send(req)
+	res.ID = req.ID
+	return
+}
+
+func (g *Gateway) instance(l lookup) (i Instance, err error) {
+	i = Instance{
+		Type:      l.Service,
+		client:    g.client,
+		namespace: l.Namespace,
+		schema:    l.Schema,
+	}
+	i.constraint, err = ParseVersionConstraint(l.Version)
+	if err != nil {
+		return
+	}
+	i.selector, err = ParseSelector(l.Selector)
+	if err != nil {
+		return
+	}
+	for _, p := range l.Preferences {
+		preference, err := ParseSelector(p)
+		if err != nil {
+			return i, err
+		}
+		i.preferences = append(i.preferences, preference)
+	}
+	return
+}
+
+type registryResolver struct {
+	d *Dispatcher
+}
+
+func (r registryResolver) Resolve(q Query) ([]EndPoint, error) {
+	var endPoints []EndPoint
+	regs := r.d.registrations(q.Namespace, q.Service)
+	r.d.countLookup(q.Namespace, q.Service, len(regs) != 0)
+	for _, reg := range regs {
+		endPoints = append(endPoints, EndPoint{
+			Address:  reg.EndPoint,
+			Version:  reg.Version,
+			Labels:   reg.Labels,
+			Schema:   reg.Schema,
+			Verified: reg.Verified,
+		})
+	}
+	return endPoints, nil
+}
+
+func (d *Dispatcher) ServeGateway(endPoint string) (err error) {
+	client, err := NewClient(d.name, ":0")
+	if err != nil {
+		return
+	}
+	client.logger.logger = d.logger.logger
+	client.metrics = d.metrics
+	client.SetResolver(registryResolver{
+		d: d,
+	})
+	g := NewGateway(d.name, &client)
+	g.logger.logger = d.logger.logger
+	err = g.Serve(endPoint)
+	if err != nil {
+		return
+	}
+	d.gateways = append(d.gateways, &g)
+	return
+}
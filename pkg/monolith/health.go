@@ -0,0 +1,205 @@
+package monolith
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	HealthService     = "monolith.Health"
+	healthCheckMethod = "Check"
+)
+
+type HealthCheck struct {
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+	SuccessThreshold int
+}
+
+var DefaultHealthCheck = HealthCheck{
+	Interval:         5 * time.Second,
+	Timeout:          time.Second,
+	FailureThreshold: 3,
+	SuccessThreshold: 1,
+}
+
+type healthStatus struct {
+	Serving bool
+}
+
+type probeState struct {
+	failures  int
+	successes int
+	unhealthy bool
+}
+
+type healthChange struct {
+	endPoint string
+	healthy  bool
+	err      error
+}
+
+func updateProbes(states map[string]*probeState, results map[string]error, check HealthCheck) (changes []healthChange) {
+	for endPoint, state := range states {
+		if _, ok := results[endPoint]; ok {
+			continue
+		}
+		// A removed server must not stay hidden if it registers again.
+		delete(states, endPoint)
+		if state.unhealthy {
+			changes = append(changes, healthChange{endPoint: endPoint, healthy: true})
+		}
+	}
+	for endPoint, err := range results {
+		state, ok := states[endPoint]
+		if !ok {
+			state = &probeState{}
+			states[endPoint] = state
+		}
+		if err != nil {
+			state.failures++
+			state.successes = 0
+			if !state.unhealthy && state.failures >= check.FailureThreshold {
+				state.unhealthy = true
+				changes = append(changes, healthChange{endPoint: endPoint, err: err})
+			}
+			continue
+		}
+		state.successes++
+		state.failures = 0
+		if state.unhealthy && state.successes >= check.SuccessThreshold {
+			state.unhealthy = false
+			changes = append(changes, healthChange{endPoint: endPoint, healthy: true})
+		}
+	}
+	return
+}
+
+func (s *Server) SetServing(serving bool) {
+	s.serving.Store(serving)
+}
+
+func (s *Server) checkHealth(req request) (res response) {
+	res.ID = req.ID
+	if req.Method != healthCheckMethod {
+		res.Err = MethodNotFoundError
+		return
+	}
+	var buffer bytes.Buffer
+	res.Err = gob.NewEncoder(&buffer).Encode(healthStatus{
+		Serving: s.serving.Load() && s.done.Err() == nil,
+	})
+	res.Results = buffer.Bytes()
+	return
+}
+
+func (d *Dispatcher) EnableHealthChecks(check HealthCheck) {
+	if check.Interval <= 0 {
+		check.Interval = DefaultHealthCheck.Interval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthCheck.Timeout
+	}
+	if check.FailureThreshold <= 0 {
+		check.FailureThreshold = DefaultHealthCheck.FailureThreshold
+	}
+	if check.SuccessThreshold <= 0 {
+		check.SuccessThreshold = DefaultHealthCheck.SuccessThreshold
+	}
+	d.logger.info("checking server health", "interval", check.Interval, "timeout", check.Timeout)
+	go d.checkHealth(check)
+}
+
+func (d *Dispatcher) checkHealth(check HealthCheck) {
+	states := make(map[string]*probeState)
+	ticker := time.NewTicker(check.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done.Done():
+			return
+		case <-ticker.C:
+		}
+		endPoints := d.services.endPoints()
+		results := make(map[string]error, len(endPoints))
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		for _, endPoint := range endPoints {
+			wg.Add(1)
+			go func(endPoint string) {
+				defer wg.Done()
+				err := probe(endPoint, check.Timeout, d.frames.withoutTimeouts())
+				lock.Lock()
+				results[endPoint] = err
+				lock.Unlock()
+			}(endPoint)
+		}
+		wg.Wait()
+		for endPoint, err := range results {
+			if err == nil {
+				d.services.touch(endPoint)
+			}
+		}
+		for _, change := range updateProbes(states, results, check) {
+			if !change.healthy {
+				d.logger.warn("server is unhealthy", "endpoint", change.endPoint, "error", change.err)
+			} else if _, ok := states[change.endPoint]; ok {
+				d.logger.info("server is healthy again", "endpoint", change.endPoint)
+			}
+			d.setHealthy(change.endPoint, change.healthy)
+		}
+	}
+}
+
+func (d *Dispatcher) setHealthy(endPoint string, healthy bool) {
+	d.applyLock.Lock()
+	defer d.applyLock.Unlock()
+	for _, op := range d.services.setHealthy(endPoint, healthy) {
+		d.notify(op)
+	}
+}
+
+func probe(endPoint string, timeout time.Duration, frames FrameLimits) (err error) {
+	conn, err := net.DialTimeout("tcp", endPoint, timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return
+	}
+	err = newFrameEncoder(conn, frames).Encode(request{
+		ID: uuid.NewString(),
+		Instance: Instance{
+			Type: HealthService,
+		},
+		Method: healthCheckMethod,
+	})
+	if err != nil {
+		return
+	}
+	var res response
+	err = newFrameDecoder(conn, frames).Decode(&res)
+	if err != nil {
+		return
+	}
+	if res.Err != nil {
+		return res.Err
+	}
+	var status healthStatus
+	err = gob.NewDecoder(bytes.NewBuffer(res.Results)).Decode(&status)
+	if err != nil {
+		return
+	}
+	if !status.Serving {
+		return NotServingError
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package monolith
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestUpdateProbes(t *testing.T) {
+	check := HealthCheck{FailureThreshold: 2, SuccessThreshold: 2}
+	failed := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		states  map[string]probeState
+		results map[string]error
+		changes []healthChange
+		after   map[string]probeState
+	}{
+		{
+			name:    "new healthy server",
+			states:  map[string]probeState{},
+			results: map[string]error{"a": nil},
+			after:   map[string]probeState{"a": {successes: 1}},
+		},
+		{
+			name:    "failure below threshold",
+			states:  map[string]probeState{"a": {successes: 3}},
+			results: map[string]error{"a": failed},
+			after:   map[string]probeState{"a": {failures: 1}},
+		},
+		{
+			name:    "failure reaches threshold",
+			states:  map[string]probeState{"a": {failures: 1}},
+			results: map[string]error{"a": failed},
+			changes: []healthChange{{endPoint: "a", err: failed}},
+			after:   map[string]probeState{"a": {failures: 2, unhealthy: true}},
+		},
+		{
+			name:    "unhealthy server keeps failing",
+			states:  map[string]probeState{"a": {failures: 2, unhealthy: true}},
+			results: map[string]error{"a": failed},
+			after:   map[string]probeState{"a": {failures: 3, unhealthy: true}},
+		},
+		{
+			name:    "success below threshold",
+			states:  map[string]probeState{"a": {failures: 4, unhealthy: true}},
+			results: map[string]error{"a": nil},
+			after:   map[string]probeState{"a": {successes: 1, unhealthy: true}},
+		},
+		{
+			name:    "success reaches threshold",
+			states:  map[string]probeState{"a": {successes: 1, unhealthy: true}},
+			results: map[string]error{"a": nil},
+			changes: []healthChange{{endPoint: "a", healthy: true}},
+			after:   map[string]probeState{"a": {successes: 2}},
+		},
+		{
+			name:    "removed healthy server is forgotten",
+			states:  map[string]probeState{"a": {failures: 1}, "b": {successes: 1}},
+			results: map[string]error{"b": nil},
+			after:   map[string]probeState{"b": {successes: 2}},
+		},
+		{
+			name:    "removed unhealthy server is forgotten and restored",
+			states:  map[string]probeState{"a": {failures: 2, unhealthy: true}},
+			results: map[string]error{},
+			changes: []healthChange{{endPoint: "a", healthy: true}},
+			after:   map[string]probeState{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			states := make(map[string]*probeState, len(test.states))
+			for endPoint, state := range test.states {
+				state := state
+				states[endPoint] = &state
+			}
+			changes := updateProbes(states, test.results, check)
+			sort.Slice(changes, func(i, k int) bool {
+				return changes[i].endPoint < changes[k].endPoint
+			})
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("changes %+v, want %+v", changes, test.changes)
+			}
+			after := make(map[string]probeState, len(states))
+			for endPoint, state := range states {
+				after[endPoint] = *state
+			}
+			if !reflect.DeepEqual(after, test.after) {
+				t.Errorf("states %+v, want %+v", after, test.after)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package monolith
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sync"
+)
+
+func EncodeID[ID comparable](id ID) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(id)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func DecodeID[ID comparable](data []byte) (id ID, err error) {
+	if len(data) == 0 {
+		err = MissingIDError
+		return
+	}
+	err = gob.NewDecoder(bytes.NewBuffer(data)).Decode(&id)
+	if err != nil {
+		err = InvalidIDError.withDetail("%v", err)
+	}
+	return
+}
+
+type singleton struct {
+	lock     sync.Mutex
+	instance any
+}
+
+type singletonKey struct{}
+
+func withSingleton(ctx context.Context, s *singleton) context.Context {
+	return context.WithValue(ctx, singletonKey{}, s)
+}
+
+func Singleton[T any](ctx context.Context, create func() (T, error)) (instance T, err error) {
+	s, ok := ctx.Value(singletonKey{}).(*singleton)
+	if !ok {
+		return create()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.instance != nil {
+		return s.instance.(T), nil
+	}
+	instance, err = create()
+	if err != nil {
+		return
+	}
+	s.instance = instance
+	return
+}
@@ -0,0 +1,27 @@
+package monolith
+
+import (
+	"context"
+	"reflect"
+)
+
+type dependenciesKey struct{}
+
+func withDependencies(ctx context.Context, dependencies []any) context.Context {
+	return context.WithValue(ctx, dependenciesKey{}, dependencies)
+}
+
+func Inject[T any](ctx context.Context) (dependency T, err error) {
+	dependencies, _ := ctx.Value(dependenciesKey{}).([]any)
+	for _, d := range dependencies {
+		if c, ok := d.(*Client); ok {
+			d = c.traced(ctx)
+		}
+		if v, ok := d.(T); ok {
+			return v, nil
+		}
+	}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	err = DependencyNotFoundError.withDetail("%v", t)
+	return
+}
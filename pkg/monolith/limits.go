@@ -0,0 +1,181 @@
+package monolith
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const capacityRetries = 2
+
+type ConcurrencyLimits struct {
+	Server     int
+	Connection int
+	Methods    map[string]int
+	Queue      int
+}
+
+var DefaultConcurrencyLimits = ConcurrencyLimits{
+	Server:     1024,
+	Connection: 128,
+	Queue:      256,
+}
+
+type limiter struct {
+	slots   chan struct{}
+	queue   int
+	waiting int
+	lock    *sync.Mutex
+}
+
+func newLimiter(limit, queue int) *limiter {
+	if limit <= 0 {
+		return nil
+	}
+	var lock sync.Mutex
+	return &limiter{
+		slots: make(chan struct{}, limit),
+		queue: queue,
+		lock:  &lock,
+	}
+}
+
+func (l *limiter) acquire(ctx context.Context, stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	l.lock.Lock()
+	if l.waiting >= l.queue {
+		l.lock.Unlock()
+		return false
+	}
+	l.waiting++
+	l.lock.Unlock()
+	defer func() {
+		l.lock.Lock()
+		l.waiting--
+		l.lock.Unlock()
+	}()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+	case <-stop:
+	}
+	return false
+}
+
+func (l *limiter) wait(stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-stop:
+	}
+	return false
+}
+
+func (l *limiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+type serverLimits struct {
+	config  ConcurrencyLimits
+	server  *limiter
+	methods SyncMap[string, *limiter]
+}
+
+func newServerLimits(config ConcurrencyLimits) *serverLimits {
+	return &serverLimits{
+		config:  config,
+		server:  newLimiter(config.Server, config.Queue),
+		methods: NewSyncMap[string, *limiter](),
+	}
+}
+
+func (l *serverLimits) method(service, method string) *limiter {
+	for _, key := range []string{service + "." + method, service} {
+		limit, ok := l.config.Methods[key]
+		if !ok {
+			continue
+		}
+		return l.methods.getOrCreate(key, func() *limiter {
+			return newLimiter(limit, l.config.Queue)
+		})
+	}
+	return nil
+}
+
+func (s *Server) SetConcurrencyLimits(limits ConcurrencyLimits) {
+	s.limits = newServerLimits(limits)
+}
+
+func (s *Server) admit(ctx context.Context, req request) (release func(), err error) {
+	err = s.frames.checkParams(req.Params)
+	if err != nil {
+		return
+	}
+	if req.Instance.Type == HealthService {
+		return func() {}, nil
+	}
+	err = s.authenticateCaller(req)
+	if err != nil {
+		return
+	}
+	if !req.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, req.Deadline)
+		defer cancel()
+	}
+	limiters := []*limiter{
+		s.limits.server,
+		s.limits.method(req.Instance.Type, req.Method),
+	}
+	for i, l := range limiters {
+		if l.acquire(ctx, s.done.Done()) {
+			continue
+		}
+		for _, acquired := range limiters[:i] {
+			acquired.release()
+		}
+		switch {
+		case s.done.Err() != nil:
+			err = NotServingError
+		case !req.Deadline.IsZero() && !time.Now().Before(req.Deadline):
+			err = DeadlineExceededError
+		default:
+			err = ResourceExhaustedError
+		}
+		return
+	}
+	release = func() {
+		for i := len(limiters) - 1; i >= 0; i-- {
+			limiters[i].release()
+		}
+	}
+	// Rejected requests keep their rate tokens; only admitted ones are charged.
+	err = s.rates.allow(req.Caller, req.Instance.Type, req.Method)
+	if err != nil {
+		release()
+		release = nil
+	}
+	return
+}
+
+func excluded(endPoints []string, endPoint string) bool {
+	for _, e := range endPoints {
+		if e == endPoint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package monolith
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		queue    int
+		held     int
+		waiting  int
+		release  bool
+		timeout  time.Duration
+		acquired bool
+	}{
+		{name: "unlimited", acquired: true},
+		{name: "free slot", limit: 2, held: 1, acquired: true},
+		{name: "full without queue", limit: 1, held: 1},
+		{name: "queued until released", limit: 1, queue: 1, held: 1, release: true, acquired: true},
+		{name: "queued until deadline", limit: 1, queue: 1, held: 1, timeout: 20 * time.Millisecond},
+		{name: "queue full", limit: 1, queue: 1, held: 1, waiting: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLimiter(test.limit, test.queue)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for i := 0; i < test.held; i++ {
+				if !l.acquire(ctx, nil) {
+					t.Fatal("failed to take a free slot")
+				}
+			}
+			for i := 0; i < test.waiting; i++ {
+				go l.acquire(ctx, nil)
+			}
+			for deadline := time.Now().Add(time.Second); l != nil && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				l.lock.Lock()
+				waiting := l.waiting
+				l.lock.Unlock()
+				if waiting == test.waiting {
+					break
+				}
+			}
+			if test.release {
+				time.AfterFunc(20*time.Millisecond, l.release)
+			}
+			acquireCtx := ctx
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				acquireCtx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			if acquired := l.acquire(acquireCtx, nil); acquired != test.acquired {
+				t.Fatalf("got acquired %v, want %v", acquired, test.acquired)
+			}
+		})
+	}
+}
+
+func TestLimiterWaitBlocksUntilRelease(t *testing.T) {
+	l := newLimiter(1, 0)
+	if !l.wait(nil) {
+		t.Fatal("failed to take a free slot")
+	}
+	acquired := make(chan bool)
+	go func() {
+		acquired <- l.wait(nil)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot that is held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release()
+	if !<-acquired {
+		t.Fatal("failed to take a released slot")
+	}
+	stop := make(chan struct{})
+	close(stop)
+	if l.wait(stop) {
+		t.Fatal("acquired a slot after stopping")
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	s := NewServer("s")
+	s.SetConcurrencyLimits(ConcurrencyLimits{Server: 1})
+	err := s.SetRateLimits(RateLimits{{Service: "Math", Rate: 0.001, Burst: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := request{
+		Instance: Instance{Type: "Math"},
+		Method:   "Add",
+		Caller:   "c",
+	}
+	steps := []struct {
+		name    string
+		release bool
+		err     error
+	}{
+		{name: "first call"},
+		{name: "server busy", err: ResourceExhaustedError},
+		{name: "token kept for the rejected call", release: true},
+		{name: "tokens used up", release: true, err: RateLimitedError},
+	}
+	var held []func()
+	for _, step := range steps {
+		if step.release {
+			for _, release := range held {
+				release()
+			}
+			held = nil
+		}
+		release, err := s.admit(context.Background(), req)
+		if !errors.Is(err, step.err) {
+			t.Fatalf("%v: got %v, want %v", step.name, err, step.err)
+		}
+		if err == nil {
+			held = append(held, release)
+		}
+	}
+}
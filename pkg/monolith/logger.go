@@ -0,0 +1,265 @@
+package monolith
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+func NewStdLogger(logger *log.Logger, level Level) Logger {
+	return stdLogger{
+		logger: logger,
+		level:  level,
+	}
+}
+
+func (l stdLogger) Debug(msg string, args ...any) {
+	l.print(LevelDebug, msg, args)
+}
+
+func (l stdLogger) Info(msg string, args ...any) {
+	l.print(LevelInfo, msg, args)
+}
+
+func (l stdLogger) Warn(msg string, args ...any) {
+	l.print(LevelWarn, msg, args)
+}
+
+func (l stdLogger) Error(msg string, args ...any) {
+	l.print(LevelError, msg, args)
+}
+
+func (l stdLogger) print(level Level, msg string, args []any) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i++ {
+		key, ok := args[i].(string)
+		if !ok || i+1 == len(args) {
+			key = "!BADKEY"
+		} else {
+			i++
+		}
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(formatLogValue(args[i]))
+	}
+	l.logger.Println(b.String())
+}
+
+func formatLogValue(v any) string {
+	var s string
+	switch v := v.(type) {
+	case error:
+		s = v.Error()
+	case time.Duration:
+		s = v.String()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var DefaultLogger = NewStdLogger(log.Default(), LevelInfo)
+
+type LogSampling struct {
+	Initial    int
+	Thereafter int
+	Tick       time.Duration
+}
+
+type logSampler struct {
+	sampling LogSampling
+	counts   map[string]int
+	reset    time.Time
+	lock     *sync.Mutex
+}
+
+func newLogSampler() *logSampler {
+	var lock sync.Mutex
+	return &logSampler{
+		counts: make(map[string]int),
+		lock:   &lock,
+	}
+}
+
+func (s *logSampler) set(sampling LogSampling) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sampling.Tick <= 0 {
+		sampling.Tick = time.Second
+	}
+	s.sampling = sampling
+	s.counts = make(map[string]int)
+}
+
+func sampleKey(msg string, args []any) string {
+	key := msg
+	for i := 0; i+1 < len(args); i += 2 {
+		if name, _ := args[i].(string); name == "service" || name == "method" {
+			key += " " + name + "=" + fmt.Sprint(args[i+1])
+		}
+	}
+	return key
+}
+
+func (s *logSampler) allow(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sampling.Initial <= 0 && s.sampling.Thereafter <= 0 {
+		return true
+	}
+	now := time.Now()
+	if now.After(s.reset) {
+		s.counts = make(map[string]int)
+		s.reset = now.Add(s.sampling.Tick)
+	}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.sampling.Initial {
+		return true
+	}
+	return s.sampling.Thereafter > 0 && (n-s.sampling.Initial)%s.sampling.Thereafter == 0
+}
+
+type nodeLogger struct {
+	logger  Logger
+	fields  []any
+	sampler *logSampler
+	status  *statusRecorder
+}
+
+func newNodeLogger(kind, name string, status *statusRecorder) nodeLogger {
+	return nodeLogger{
+		logger:  DefaultLogger,
+		fields:  []any{kind, name},
+		sampler: newLogSampler(),
+		status:  status,
+	}
+}
+
+func (l nodeLogger) with(args []any) []any {
+	return append(append(make([]any, 0, len(l.fields)+len(args)), l.fields...), args...)
+}
+
+func (l nodeLogger) debug(msg string, args ...any) {
+	if l.logger != nil {
+		l.logger.Debug(msg, l.with(args)...)
+	}
+}
+
+func (l nodeLogger) request(msg string, args ...any) {
+	if l.logger != nil && l.sampler.allow(sampleKey(msg, args)) {
+		l.logger.Debug(msg, l.with(args)...)
+	}
+}
+
+func (l nodeLogger) info(msg string, args ...any) {
+	if l.logger != nil {
+		l.logger.Info(msg, l.with(args)...)
+	}
+}
+
+func (l nodeLogger) warn(msg string, args ...any) {
+	l.record(msg, args)
+	if l.logger != nil {
+		l.logger.Warn(msg, l.with(args)...)
+	}
+}
+
+func (l nodeLogger) error(msg string, args ...any) {
+	l.record(msg, args)
+	if l.logger != nil {
+		l.logger.Error(msg, l.with(args)...)
+	}
+}
+
+func (l nodeLogger) record(msg string, args []any) {
+	if l.status == nil {
+		return
+	}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			msg += ": " + err.Error()
+		}
+	}
+	l.status.recordError(msg)
+}
+
+func (c *Client) SetLogger(logger Logger) {
+	c.logger.logger = logger
+}
+
+func (c *Client) SetLogSampling(sampling LogSampling) {
+	c.logger.sampler.set(sampling)
+}
+
+func (s *Server) SetLogger(logger Logger) {
+	s.logger.logger = logger
+}
+
+func (s *Server) SetLogSampling(sampling LogSampling) {
+	s.logger.sampler.set(sampling)
+}
+
+func (d *Dispatcher) SetLogger(logger Logger) {
+	d.logger.logger = logger
+}
+
+func (d *Dispatcher) SetLogSampling(sampling LogSampling) {
+	d.logger.sampler.set(sampling)
+}
+
+func (g *Gateway) SetLogger(logger Logger) {
+	g.logger.logger = logger
+}
+
+func (g *Gateway) SetLogSampling(sampling LogSampling) {
+	g.logger.sampler.set(sampling)
+}
@@ -0,0 +1,59 @@
+package monolith
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogSampling(t *testing.T) {
+	type call struct {
+		service, method string
+	}
+	tests := []struct {
+		name     string
+		sampling LogSampling
+		calls    []call
+		logged   []int
+	}{
+		{
+			name:   "unsampled",
+			calls:  []call{{"Math", "Add"}, {"Math", "Add"}, {"Math", "Add"}},
+			logged: []int{0, 1, 2},
+		},
+		{
+			name:     "initial then every second",
+			sampling: LogSampling{Initial: 1, Thereafter: 2, Tick: time.Hour},
+			calls:    []call{{"Math", "Add"}, {"Math", "Add"}, {"Math", "Add"}, {"Math", "Add"}, {"Math", "Add"}},
+			logged:   []int{0, 2, 4},
+		},
+		{
+			name:     "keyed by service and method",
+			sampling: LogSampling{Initial: 1, Tick: time.Hour},
+			calls:    []call{{"Math", "Add"}, {"Math", "Add"}, {"Math", "Sub"}, {"Strings", "Add"}, {"Math", "Sub"}},
+			logged:   []int{0, 2, 3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			l := newNodeLogger("server", "s", nil)
+			l.logger = NewStdLogger(log.New(&output, "", 0), LevelDebug)
+			l.sampler.set(test.sampling)
+			var logged []int
+			for i, c := range test.calls {
+				output.Reset()
+				l.request("received request", "service", c.service, "method", c.method)
+				if strings.Contains(output.String(), "received request") {
+					logged = append(logged, i)
+				}
+			}
+			if !reflect.DeepEqual(logged, test.logged) {
+				t.Fatalf("logged calls %v, want %v", logged, test.logged)
+			}
+		})
+	}
+}
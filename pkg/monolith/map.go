@@ -33,3 +33,43 @@ func (sm *SyncMap[K, V]) delete(key K) {
 	delete(sm.m, key)
 	sm.lock.Unlock()
 }
+
+func (sm *SyncMap[K, V]) size() int {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return len(sm.m)
+}
+
+func (sm *SyncMap[K, V]) getOrCreate(key K, create func() V) V {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	value, ok := sm.m[key]
+	if !ok {
+		value = create()
+		sm.m[key] = value
+	}
+	return value
+}
+
+func (sm *SyncMap[K, V]) values() []V {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	values := make([]V, 0, len(sm.m))
+	for _, value := range sm.m {
+		values = append(values, value)
+	}
+	return values
+}
+
+func (sm *SyncMap[K, V]) removeWhere(match func(key K, value V) bool) []V {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	var removed []V
+	for key, value := range sm.m {
+		if match(key, value) {
+			removed = append(removed, value)
+			delete(sm.m, key)
+		}
+	}
+	return removed
+}
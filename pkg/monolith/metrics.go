@@ -0,0 +1,319 @@
+package monolith
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const unknownLabel = "unknown"
+
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Metrics interface {
+	Count(name string, labels map[string]string, delta float64)
+	Gauge(name string, labels map[string]string, value float64)
+	Observe(name string, labels map[string]string, value float64)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) Count(string, map[string]string, float64)   {}
+func (noMetrics) Gauge(string, map[string]string, float64)   {}
+func (noMetrics) Observe(string, map[string]string, float64) {}
+
+func (c *Client) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = noMetrics{}
+	}
+	c.metrics = metrics
+}
+
+func (s *Server) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = noMetrics{}
+	}
+	s.metrics = metrics
+}
+
+func (d *Dispatcher) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = noMetrics{}
+	}
+	d.metrics = metrics
+	d.metrics.Gauge("monolith_dispatcher_registrations", nil, float64(d.services.size()))
+}
+
+func (c *Client) observeCall(service, method string, sent, received int, err error, elapsed time.Duration) {
+	labels := map[string]string{
+		"service": service,
+		"method":  method,
+	}
+	c.metrics.Observe("monolith_client_call_duration_seconds", labels, elapsed.Seconds())
+	c.metrics.Count("monolith_client_sent_bytes_total", labels, float64(sent))
+	c.metrics.Count("monolith_client_received_bytes_total", labels, float64(received))
+	c.metrics.Count("monolith_client_calls_total", map[string]string{
+		"service": service,
+		"method":  method,
+		"code":    ErrorCode(err),
+	}, 1)
+}
+
+func (c *Client) countConnection(delta int64) {
+	n := c.connections.Add(delta)
+	c.metrics.Gauge("monolith_client_connections", nil, float64(n))
+}
+
+func requestLabels(req request, processed bool, err error) (service, method string) {
+	// Clients can name any service and method, so only registered ones become
+	// labels; anything else would grow the series without bound.
+	service, method = unknownLabel, unknownLabel
+	if req.Instance.Type == HealthService {
+		service = HealthService
+		if req.Method == healthCheckMethod {
+			method = req.Method
+		}
+		return
+	}
+	key, _, ok := findTypeHandler(req.Instance.Type, req.Instance.Version)
+	if !ok {
+		return
+	}
+	service = req.Instance.Type
+	if schema, ok := typeSchemas[key]; ok {
+		if _, ok := schema.method(req.Method); ok {
+			method = req.Method
+		}
+		return
+	}
+	// Without a schema only a handler that ran knows its methods.
+	if processed && !errors.Is(err, MethodNotFoundError) {
+		method = req.Method
+	}
+	return
+}
+
+func (s *Server) observeRequest(service, method string, received, sent int, err error, elapsed time.Duration) {
+	labels := map[string]string{
+		"service": service,
+		"method":  method,
+	}
+	s.metrics.Observe("monolith_server_request_duration_seconds", labels, elapsed.Seconds())
+	s.metrics.Count("monolith_server_received_bytes_total", labels, float64(received))
+	s.metrics.Count("monolith_server_sent_bytes_total", labels, float64(sent))
+	s.metrics.Count("monolith_server_requests_total", map[string]string{
+		"service": service,
+		"method":  method,
+		"code":    ErrorCode(err),
+	}, 1)
+}
+
+func (s *Server) track(conn net.Conn) func() {
+	untrack := trackConnection(s.connections, conn, "client")
+	s.metrics.Gauge("monolith_server_connections", nil, float64(s.connections.size()))
+	return func() {
+		untrack()
+		s.metrics.Gauge("monolith_server_connections", nil, float64(s.connections.size()))
+	}
+}
+
+func (d *Dispatcher) countConnections(kind string) {
+	n := 0
+	for _, c := range d.connections.values() {
+		if c.kind == kind {
+			n++
+		}
+	}
+	d.metrics.Gauge("monolith_dispatcher_connections", map[string]string{
+		"kind": kind,
+	}, float64(n))
+}
+
+func (d *Dispatcher) countLookup(namespace, service string, found bool) {
+	result := "found"
+	if !found {
+		result = "not_found"
+		namespace, service = unknownLabel, unknownLabel
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	d.metrics.Count("monolith_dispatcher_lookups_total", map[string]string{
+		"namespace": namespace,
+		"service":   service,
+		"result":    result,
+	}, 1)
+}
+
+const (
+	counterKind   = "counter"
+	gaugeKind     = "gauge"
+	histogramKind = "histogram"
+)
+
+type metricFamily struct {
+	kind   string
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels  map[string]string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+type PrometheusMetrics struct {
+	buckets  []float64
+	families map[string]*metricFamily
+	lock     *sync.Mutex
+}
+
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	var lock sync.Mutex
+	return &PrometheusMetrics{
+		buckets:  buckets,
+		families: make(map[string]*metricFamily),
+		lock:     &lock,
+	}
+}
+
+func (p *PrometheusMetrics) series(name, kind string, labels map[string]string) *metricSeries {
+	f, ok := p.families[name]
+	if !ok {
+		f = &metricFamily{
+			kind:   kind,
+			series: make(map[string]*metricSeries),
+		}
+		p.families[name] = f
+	}
+	if f.kind != kind {
+		return nil
+	}
+	key := formatLabels(labels, "", "")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{
+			labels: labels,
+		}
+		if kind == histogramKind {
+			s.buckets = make([]uint64, len(p.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (p *PrometheusMetrics) Count(name string, labels map[string]string, delta float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if s := p.series(name, counterKind, labels); s != nil && delta > 0 {
+		s.value += delta
+	}
+}
+
+func (p *PrometheusMetrics) Gauge(name string, labels map[string]string, value float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if s := p.series(name, gaugeKind, labels); s != nil {
+		s.value = value
+	}
+}
+
+func (p *PrometheusMetrics) Observe(name string, labels map[string]string, value float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s := p.series(name, histogramKind, labels)
+	if s == nil {
+		return
+	}
+	for i, bound := range p.buckets {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = p.Write(w)
+}
+
+func (p *PrometheusMetrics) Write(w io.Writer) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	b := bufio.NewWriter(w)
+	var names []string
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := p.families[name]
+		fmt.Fprintf(b, "# TYPE %v %v\n", name, f.kind)
+		var keys []string
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != histogramKind {
+				fmt.Fprintf(b, "%v%v %v\n", name, key, formatValue(s.value))
+				continue
+			}
+			for i, bound := range p.buckets {
+				fmt.Fprintf(b, "%v_bucket%v %v\n", name, formatLabels(s.labels, "le", formatValue(bound)), s.buckets[i])
+			}
+			fmt.Fprintf(b, "%v_bucket%v %v\n", name, formatLabels(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%v_sum%v %v\n", name, key, formatValue(s.value))
+			fmt.Fprintf(b, "%v_count%v %v\n", name, key, s.count)
+		}
+	}
+	return b.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels map[string]string, extraName, extraValue string) string {
+	var pairs []string
+	for name, value := range labels {
+		pairs = append(pairs, name+"=\""+labelEscaper.Replace(value)+"\"")
+	}
+	sort.Strings(pairs)
+	if extraName != "" {
+		pairs = append(pairs, extraName+"=\""+extraValue+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
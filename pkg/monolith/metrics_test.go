@@ -0,0 +1,74 @@
+package monolith
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRequestLabels(t *testing.T) {
+	handler := func(ctx context.Context, id []byte, method string, decode func(any) error, encode func(any) error) error {
+		return nil
+	}
+	RegisterTypeHandler("LabelsUntyped", handler)
+	if err := RegisterVersionedTypeHandler("LabelsTyped", "1.0.0", handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTypeSchema(Schema{Service: "LabelsTyped", Version: "1.0.0", Methods: []MethodSchema{{Name: "Add"}}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		service   string
+		method    string
+		processed bool
+		err       error
+		labels    string
+	}{
+		{name: "schema method", service: "LabelsTyped", method: "Add", labels: "LabelsTyped.Add"},
+		{name: "method missing from schema", service: "LabelsTyped", method: "Drop", processed: true, labels: "LabelsTyped.unknown"},
+		{name: "handled method without schema", service: "LabelsUntyped", method: "Add", processed: true, labels: "LabelsUntyped.Add"},
+		{name: "failed method without schema", service: "LabelsUntyped", method: "Add", processed: true, err: RateLimitedError, labels: "LabelsUntyped.Add"},
+		{name: "unknown method without schema", service: "LabelsUntyped", method: "Drop", processed: true, err: MethodNotFoundError, labels: "LabelsUntyped.unknown"},
+		{name: "rejected before the handler", service: "LabelsUntyped", method: "Drop", err: RateLimitedError, labels: "LabelsUntyped.unknown"},
+		{name: "unregistered service", service: "Random", method: "Add", processed: true, err: UnregisteredTypeError, labels: "unknown.unknown"},
+		{name: "health check", service: HealthService, method: healthCheckMethod, processed: true, labels: HealthService + "." + healthCheckMethod},
+		{name: "unknown health method", service: HealthService, method: "Drop", processed: true, err: MethodNotFoundError, labels: HealthService + ".unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := request{Instance: Instance{Type: test.service}, Method: test.method}
+			service, method := requestLabels(req, test.processed, test.err)
+			if labels := service + "." + method; labels != test.labels {
+				t.Fatalf("got %v, want %v", labels, test.labels)
+			}
+		})
+	}
+}
+
+func TestCountLookupHidesMissingServices(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	d := NewDispatcher("d")
+	d.SetMetrics(metrics)
+	defer d.Shutdown()
+	d.countLookup("billing", "Math", true)
+	d.countLookup("billing", "Random1", false)
+	d.countLookup("other", "Random2", false)
+	var buffer bytes.Buffer
+	if err := metrics.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	out := buffer.String()
+	for _, want := range []string{
+		`namespace="billing",result="found",service="Math"} 1`,
+		`namespace="unknown",result="not_found",service="unknown"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %v in\n%v", want, out)
+		}
+	}
+	if strings.Contains(out, "Random") {
+		t.Errorf("client supplied names became labels:\n%v", out)
+	}
+}
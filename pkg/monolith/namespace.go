@@ -0,0 +1,112 @@
+package monolith
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultNamespace = "default"
+	AllNamespaces    = "*"
+)
+
+func qualify(namespace, service string) string {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return namespace + "/" + service
+}
+
+func splitQualified(name string) (namespace, service string) {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return DefaultNamespace, name
+	}
+	return name[:i], name[i+1:]
+}
+
+func validNamespace(namespace string) bool {
+	return namespace != AllNamespaces && !strings.ContainsAny(namespace, "/ \t\n")
+}
+
+type exports struct {
+	targets map[string]map[string]bool
+	lock    *sync.RWMutex
+}
+
+func newExports() exports {
+	return exports{
+		targets: make(map[string]map[string]bool),
+		lock:    &sync.RWMutex{},
+	}
+}
+
+func (d *Dispatcher) Export(namespace, service, toNamespace string) {
+	d.exports.lock.Lock()
+	defer d.exports.lock.Unlock()
+	name := qualify(namespace, service)
+	targets, ok := d.exports.targets[name]
+	if !ok {
+		targets = make(map[string]bool)
+		d.exports.targets[name] = targets
+	}
+	if toNamespace == "" {
+		toNamespace = DefaultNamespace
+	}
+	targets[toNamespace] = true
+	d.logger.info("exported service", "service", name, "namespace", toNamespace)
+}
+
+func (d *Dispatcher) visible(namespace, service string) []string {
+	names := []string{qualify(namespace, service)}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	d.exports.lock.RLock()
+	defer d.exports.lock.RUnlock()
+	for name, targets := range d.exports.targets {
+		from, exported := splitQualified(name)
+		if exported != service || from == namespace {
+			continue
+		}
+		if targets[namespace] || targets[AllNamespaces] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (d *Dispatcher) audience(name string) (namespaces []string, allNamespaces bool) {
+	namespace, _ := splitQualified(name)
+	namespaces = []string{namespace}
+	d.exports.lock.RLock()
+	defer d.exports.lock.RUnlock()
+	for target := range d.exports.targets[name] {
+		if target == AllNamespaces {
+			allNamespaces = true
+			continue
+		}
+		namespaces = append(namespaces, target)
+	}
+	return
+}
+
+func (d *Dispatcher) resolve(l lookup) (registration, bool) {
+	return d.services.resolveIn(d.visible(l.Namespace, l.Service), l)
+}
+
+func (d *Dispatcher) registrations(namespace, service string) []registration {
+	var regs []registration
+	for _, name := range d.visible(namespace, service) {
+		regs = append(regs, d.services.registrations(name)...)
+	}
+	return regs
+}
+
+func (s *Server) SetNamespace(namespace string) {
+	s.namespace = namespace
+}
+
+func (c *Client) SetNamespace(namespace string) {
+	c.namespace = namespace
+}
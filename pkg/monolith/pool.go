@@ -0,0 +1,105 @@
+package monolith
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	minEndPointCooldown = time.Second
+	maxEndPointCooldown = 30 * time.Second
+)
+
+type EndPointOrder int
+
+const (
+	InOrder EndPointOrder = iota
+	RandomOrder
+)
+
+type endPointHealth struct {
+	failures int
+	retryAt  time.Time
+}
+
+type endPointPool struct {
+	endPoints []string
+	order     EndPointOrder
+	health    map[string]endPointHealth
+	lock      *sync.Mutex
+}
+
+func newEndPointPool(endPoints []string) *endPointPool {
+	return &endPointPool{
+		endPoints: endPoints,
+		health:    make(map[string]endPointHealth),
+		lock:      &sync.Mutex{},
+	}
+}
+
+func (p *endPointPool) setOrder(order EndPointOrder) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.order = order
+}
+
+func (p *endPointPool) candidates() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	endPoints := make([]string, len(p.endPoints))
+	copy(endPoints, p.endPoints)
+	if p.order == RandomOrder {
+		rand.Shuffle(len(endPoints), func(i, j int) {
+			endPoints[i], endPoints[j] = endPoints[j], endPoints[i]
+		})
+	}
+	now := time.Now()
+	sort.SliceStable(endPoints, func(i, j int) bool {
+		a := p.health[endPoints[i]].retryAt
+		b := p.health[endPoints[j]].retryAt
+		if !a.After(now) && !b.After(now) {
+			return false
+		}
+		return a.Before(b)
+	})
+	return endPoints
+}
+
+func (p *endPointPool) markFailed(endPoint string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	h := p.health[endPoint]
+	cooldown := minEndPointCooldown << h.failures
+	if cooldown > maxEndPointCooldown || cooldown <= 0 {
+		cooldown = maxEndPointCooldown
+	} else {
+		h.failures++
+	}
+	h.retryAt = time.Now().Add(cooldown)
+	p.health[endPoint] = h
+}
+
+func (p *endPointPool) markHealthy(endPoint string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.health, endPoint)
+}
+
+func (p *endPointPool) try(f func(endPoint string) error) (err error) {
+	for _, endPoint := range p.candidates() {
+		err = f(endPoint)
+		switch {
+		case err == nil:
+			p.markHealthy(endPoint)
+			return
+		case errors.Is(err, ServiceNotFoundError):
+			p.markHealthy(endPoint)
+		default:
+			p.markFailed(endPoint)
+		}
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package monolith
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	rateBucketIdle = 10 * time.Minute
+	maxRateBuckets = 16384
+)
+
+type RateLimit struct {
+	Caller     string  `json:"caller,omitempty" yaml:"caller,omitempty"`
+	Service    string  `json:"service,omitempty" yaml:"service,omitempty"`
+	Method     string  `json:"method,omitempty" yaml:"method,omitempty"`
+	Rate       float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+	Burst      int     `json:"burst,omitempty" yaml:"burst,omitempty"`
+	DailyQuota int     `json:"dailyQuota,omitempty" yaml:"dailyQuota,omitempty"`
+}
+
+type RateLimits []RateLimit
+
+func LoadRateLimits(path string) (limits RateLimits, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &limits)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&limits)
+	}
+	if err != nil {
+		return
+	}
+	err = limits.validate()
+	return
+}
+
+func (limits RateLimits) validate() error {
+	for _, l := range limits {
+		if l.Rate < 0 || l.Burst < 0 || l.DailyQuota < 0 || (l.Rate == 0 && l.DailyQuota == 0) {
+			return InvalidRateLimitError
+		}
+	}
+	return nil
+}
+
+func (l RateLimit) matches(caller, service, method string) bool {
+	return (l.Caller == "" || l.Caller == caller) &&
+		(l.Service == "" || l.Service == service) &&
+		(l.Method == "" || l.Method == method)
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+type rateKey struct {
+	rule     int
+	caller   string
+	overflow bool
+}
+
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+	day     string
+	used    int
+}
+
+func (b *rateBucket) refill(l RateLimit, now time.Time) {
+	if l.Rate > 0 {
+		b.tokens = math.Min(l.burst(), b.tokens+now.Sub(b.updated).Seconds()*l.Rate)
+	}
+	b.updated = now
+	day := now.UTC().Format("2006-01-02")
+	if b.day != day {
+		b.day = day
+		b.used = 0
+	}
+}
+
+type rateLimiter struct {
+	limits     RateLimits
+	buckets    map[rateKey]*rateBucket
+	maxBuckets int
+	pruned     time.Time
+	lock       *sync.Mutex
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	return &rateLimiter{
+		limits:     limits,
+		buckets:    make(map[rateKey]*rateBucket),
+		maxBuckets: maxRateBuckets,
+		pruned:     time.Now(),
+		lock:       &sync.Mutex{},
+	}
+}
+
+func (r *rateLimiter) allow(caller, service, method string) error {
+	return r.allowAt(caller, service, method, time.Now())
+}
+
+func (r *rateLimiter) allowAt(caller, service, method string, now time.Time) error {
+	if len(r.limits) == 0 {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.prune(now)
+	var matched []*rateBucket
+	var rules []RateLimit
+	for i, l := range r.limits {
+		if !l.matches(caller, service, method) {
+			continue
+		}
+		key := rateKey{
+			rule:   i,
+			caller: caller,
+		}
+		b, ok := r.buckets[key]
+		if !ok && len(r.buckets) >= r.maxBuckets {
+			// Callers beyond the cap share one bucket per rule, so made up
+			// caller names cannot grow the map or start with a full burst.
+			key = rateKey{
+				rule:     i,
+				overflow: true,
+			}
+			b, ok = r.buckets[key]
+		}
+		if !ok {
+			b = &rateBucket{
+				tokens:  l.burst(),
+				updated: now,
+			}
+			r.buckets[key] = b
+		}
+		b.refill(l, now)
+		if l.Rate > 0 && b.tokens < 1 {
+			return RateLimitedError
+		}
+		if l.DailyQuota > 0 && b.used >= l.DailyQuota {
+			return QuotaExceededError
+		}
+		matched = append(matched, b)
+		rules = append(rules, l)
+	}
+	for i, b := range matched {
+		if rules[i].Rate > 0 {
+			b.tokens--
+		}
+		b.used++
+	}
+	return nil
+}
+
+func (r *rateLimiter) prune(now time.Time) {
+	if now.Sub(r.pruned) < rateBucketIdle {
+		return
+	}
+	r.pruned = now
+	day := now.UTC().Format("2006-01-02")
+	for key, b := range r.buckets {
+		if now.Sub(b.updated) >= rateBucketIdle && (b.day != day || r.limits[key.rule].DailyQuota == 0) {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+func (s *Server) SetRateLimits(limits RateLimits) error {
+	err := limits.validate()
+	if err != nil {
+		return err
+	}
+	s.rates = newRateLimiter(limits)
+	return nil
+}
@@ -0,0 +1,100 @@
+package monolith
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	type call struct {
+		caller string
+		after  time.Duration
+		err    error
+	}
+	tests := []struct {
+		name       string
+		limits     RateLimits
+		maxBuckets int
+		calls      []call
+	}{
+		{
+			name:   "token bucket",
+			limits: RateLimits{{Rate: 1, Burst: 2}},
+			calls: []call{
+				{caller: "a"},
+				{caller: "a"},
+				{caller: "a", err: RateLimitedError},
+				{caller: "a", after: 500 * time.Millisecond, err: RateLimitedError},
+				{caller: "a", after: time.Second},
+				{caller: "a", after: time.Second, err: RateLimitedError},
+				{caller: "a", after: time.Minute},
+				{caller: "a", after: time.Minute},
+				{caller: "a", after: time.Minute, err: RateLimitedError},
+			},
+		},
+		{
+			name:   "buckets per caller",
+			limits: RateLimits{{Rate: 1}},
+			calls: []call{
+				{caller: "a"},
+				{caller: "a", err: RateLimitedError},
+				{caller: "b"},
+			},
+		},
+		{
+			name:   "daily quota rolls over at midnight UTC",
+			limits: RateLimits{{DailyQuota: 2}},
+			calls: []call{
+				{caller: "a"},
+				{caller: "a", after: 30 * time.Second},
+				{caller: "a", after: 59 * time.Second, err: QuotaExceededError},
+				{caller: "b", after: 59 * time.Second},
+				{caller: "a", after: time.Minute},
+				{caller: "a", after: time.Minute},
+				{caller: "a", after: time.Minute, err: QuotaExceededError},
+			},
+		},
+		{
+			name:   "rejected calls do not use other rules",
+			limits: RateLimits{{DailyQuota: 2}, {Caller: "a", Rate: 1}},
+			calls: []call{
+				{caller: "a"},
+				{caller: "a", err: RateLimitedError},
+				{caller: "a", after: time.Second},
+				{caller: "a", after: 2 * time.Second, err: QuotaExceededError},
+			},
+		},
+		{
+			name:       "callers beyond the cap share a bucket",
+			limits:     RateLimits{{Rate: 1}},
+			maxBuckets: 2,
+			calls: []call{
+				{caller: "a"},
+				{caller: "b"},
+				{caller: "c"},
+				{caller: "d", err: RateLimitedError},
+				{caller: "a", after: time.Second},
+				{caller: "d", after: time.Second},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRateLimiter(test.limits)
+			if test.maxBuckets > 0 {
+				r.maxBuckets = test.maxBuckets
+			}
+			for i, c := range test.calls {
+				err := r.allowAt(c.caller, "Math", "Add", start.Add(c.after))
+				if !errors.Is(err, c.err) {
+					t.Fatalf("call %v by %q at +%v: got %v, want %v", i, c.caller, c.after, err, c.err)
+				}
+			}
+			if test.maxBuckets > 0 && len(r.buckets) > test.maxBuckets+len(test.limits) {
+				t.Fatalf("got %v buckets, want at most %v", len(r.buckets), test.maxBuckets+len(test.limits))
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package monolith
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const WeightLabel = "weight"
+
+type registry struct {
+	services  map[string]map[string]map[string]registration
+	unhealthy map[string]bool
+	lastSeen  map[string]time.Time
+	lock      *sync.RWMutex
+}
+
+func newRegistry() registry {
+	var lock sync.RWMutex
+	return registry{
+		services:  make(map[string]map[string]map[string]registration),
+		unhealthy: make(map[string]bool),
+		lastSeen:  make(map[string]time.Time),
+		lock:      &lock,
+	}
+}
+
+func (r *registry) get(service, version, endPoint string) (reg registration, ok bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	reg, ok = r.services[service][version][endPoint]
+	return
+}
+
+func (r *registry) size() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	n := 0
+	for _, versions := range r.services {
+		for _, endPoints := range versions {
+			n += len(endPoints)
+		}
+	}
+	return n
+}
+
+func (r *registry) touch(endPoint string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lastSeen[endPoint] = time.Now()
+}
+
+func (r *registry) available(reg registration) bool {
+	return !reg.Draining && !r.unhealthy[reg.EndPoint]
+}
+
+func (r *registry) put(service string, reg registration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.insert(service, reg)
+}
+
+func (r *registry) insert(service string, reg registration) {
+	versions, ok := r.services[service]
+	if !ok {
+		versions = make(map[string]map[string]registration)
+		r.services[service] = versions
+	}
+	endPoints, ok := versions[reg.Version]
+	if !ok {
+		endPoints = make(map[string]registration)
+		versions[reg.Version] = endPoints
+	}
+	endPoints[reg.EndPoint] = reg
+}
+
+func (r *registry) remove(service string, reg registration) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	endPoints := r.services[service][reg.Version]
+	if _, ok := endPoints[reg.EndPoint]; !ok {
+		return false
+	}
+	delete(endPoints, reg.EndPoint)
+	if !r.registered(reg.EndPoint) {
+		delete(r.lastSeen, reg.EndPoint)
+	}
+	if len(endPoints) == 0 {
+		delete(r.services[service], reg.Version)
+	}
+	if len(r.services[service]) == 0 {
+		delete(r.services, service)
+	}
+	return true
+}
+
+func (r *registry) apply(op registryOp) {
+	switch op.Kind {
+	case putOp:
+		r.put(op.Service, op.Registration)
+	case removeOp:
+		r.remove(op.Service, op.Registration)
+	}
+}
+
+func (r *registry) registrations(service string) []registration {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var regs []registration
+	for _, endPoints := range r.services[service] {
+		for _, reg := range endPoints {
+			if r.available(reg) {
+				regs = append(regs, reg)
+			}
+		}
+	}
+	return regs
+}
+
+func (r *registry) endPoints() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	seen := make(map[string]bool)
+	var endPoints []string
+	for _, versions := range r.services {
+		for _, regs := range versions {
+			for endPoint := range regs {
+				if !seen[endPoint] {
+					seen[endPoint] = true
+					endPoints = append(endPoints, endPoint)
+				}
+			}
+		}
+	}
+	return endPoints
+}
+
+func (r *registry) registered(endPoint string) bool {
+	for _, versions := range r.services {
+		for _, endPoints := range versions {
+			if _, ok := endPoints[endPoint]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *registry) isAvailable(reg registration) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.available(reg)
+}
+
+func (r *registry) status(endPoint string) (healthy bool, lastSeen time.Time) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return !r.unhealthy[endPoint], r.lastSeen[endPoint]
+}
+
+func (r *registry) setHealthy(endPoint string, healthy bool) (changed []registryOp) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.unhealthy[endPoint] == !healthy {
+		return
+	}
+	if healthy {
+		delete(r.unhealthy, endPoint)
+	} else {
+		r.unhealthy[endPoint] = true
+	}
+	kind := putOp
+	if !healthy {
+		kind = removeOp
+	}
+	for service, versions := range r.services {
+		for _, regs := range versions {
+			if reg, ok := regs[endPoint]; ok {
+				changed = append(changed, registryOp{
+					Kind:         kind,
+					Service:      service,
+					Registration: reg,
+				})
+			}
+		}
+	}
+	return
+}
+
+func (r *registry) replace(service string, regs []registration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.services, service)
+	for _, reg := range regs {
+		r.insert(service, reg)
+	}
+}
+
+func (r *registry) reset(ops []registryOp) (changed []registryOp) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	kept := make(map[[3]string]bool)
+	for _, op := range ops {
+		kept[[3]string{op.Service, op.Registration.Version, op.Registration.EndPoint}] = true
+	}
+	for service, versions := range r.services {
+		for version, endPoints := range versions {
+			for endPoint, reg := range endPoints {
+				if !kept[[3]string{service, version, endPoint}] {
+					changed = append(changed, registryOp{
+						Kind:         removeOp,
+						Service:      service,
+						Registration: reg,
+					})
+				}
+			}
+		}
+	}
+	r.services = make(map[string]map[string]map[string]registration)
+	for _, op := range ops {
+		r.insert(op.Service, op.Registration)
+	}
+	return append(changed, ops...)
+}
+
+func (r *registry) ops() []registryOp {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	var ops []registryOp
+	for service, versions := range r.services {
+		for _, endPoints := range versions {
+			for _, reg := range endPoints {
+				ops = append(ops, registryOp{
+					Kind:         putOp,
+					Service:      service,
+					Registration: reg,
+				})
+			}
+		}
+	}
+	return ops
+}
+
+func (r *registry) resolve(l lookup) (best registration, ok bool) {
+	return r.resolveIn([]string{l.Service}, l)
+}
+
+func (r *registry) resolveIn(names []string, l lookup) (best registration, ok bool) {
+	constraint, err := ParseVersionConstraint(l.Version)
+	if err != nil {
+		return
+	}
+	selector, err := ParseSelector(l.Selector)
+	if err != nil {
+		return
+	}
+	var preferences []Selector
+	for _, p := range l.Preferences {
+		preference, err := ParseSelector(p)
+		if err != nil {
+			return best, false
+		}
+		preferences = append(preferences, preference)
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	versions := make(map[string][]registration)
+	for _, name := range names {
+		for text, endPoints := range r.services[name] {
+			for _, reg := range endPoints {
+				versions[text] = append(versions[text], reg)
+			}
+		}
+	}
+	var bestVersion Version
+	var candidates []registration
+	for text, endPoints := range versions {
+		var v Version
+		if text != "" {
+			v, err = ParseVersion(text)
+			if err != nil {
+				continue
+			}
+		} else if len(constraint.bounds) != 0 {
+			continue
+		}
+		if !constraint.Matches(v) || (candidates != nil && v.Compare(bestVersion) <= 0) {
+			continue
+		}
+		var matching []registration
+		for _, reg := range endPoints {
+			if selector.Matches(reg.Labels) && r.available(reg) && !excluded(l.Exclude, reg.EndPoint) {
+				matching = append(matching, reg)
+			}
+		}
+		if len(matching) != 0 {
+			candidates, bestVersion = matching, v
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	return choose(rank(candidates, preferences)), true
+}
+
+func rank(candidates []registration, preferences []Selector) []registration {
+	bestScore := -1
+	var best []registration
+	for _, reg := range candidates {
+		score := 0
+		if reg.Verified {
+			score = 1
+		}
+		for _, p := range preferences {
+			score <<= 1
+			if p.Matches(reg.Labels) {
+				score |= 1
+			}
+		}
+		switch {
+		case score > bestScore:
+			bestScore = score
+			best = []registration{reg}
+		case score == bestScore:
+			best = append(best, reg)
+		}
+	}
+	return best
+}
+
+func weight(reg registration) int {
+	w, err := strconv.Atoi(reg.Labels[WeightLabel])
+	if err != nil || w < 0 {
+		return 1
+	}
+	return w
+}
+
+func choose(candidates []registration) registration {
+	total := 0
+	for _, reg := range candidates {
+		total += weight(reg)
+	}
+	if total == 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	n := rand.Intn(total)
+	for _, reg := range candidates {
+		n -= weight(reg)
+		if n < 0 {
+			return reg
+		}
+	}
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,251 @@
+package monolith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultFilePollInterval = time.Second
+	PriorityLabel           = "priority"
+)
+
+type Query struct {
+	Namespace   string
+	Service     string
+	Version     VersionConstraint
+	Selector    Selector
+	Preferences []Selector
+}
+
+type EndPoint struct {
+	Address  string            `json:"address" yaml:"address"`
+	Version  string            `json:"version,omitempty" yaml:"version,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Schema   Schema            `json:"-" yaml:"-"`
+	Verified bool              `json:"-" yaml:"-"`
+}
+
+type Resolver interface {
+	Resolve(q Query) ([]EndPoint, error)
+}
+
+func (c *Client) SetResolver(resolver Resolver) {
+	c.resolver = resolver
+}
+
+func (i Instance) query() Query {
+	return Query{
+		Namespace:   i.namespace,
+		Service:     i.Type,
+		Version:     i.constraint,
+		Selector:    i.selector,
+		Preferences: i.preferences,
+	}
+}
+
+func (i Instance) resolveEndPoint() (reg registration, err error) {
+	endPoints, err := i.client.resolver.Resolve(i.query())
+	if err != nil {
+		return
+	}
+	candidates := newRegistry()
+	for _, e := range endPoints {
+		version, err := canonicalVersion(e.Version)
+		if err != nil {
+			i.client.logger.warn("resolver returned an invalid version", "service", i.Type, "endpoint", e.Address, "error", err)
+			continue
+		}
+		candidates.put(i.Type, registration{
+			Version:  version,
+			EndPoint: e.Address,
+			Labels:   e.Labels,
+			Schema:   e.Schema,
+			Verified: e.Verified,
+		})
+	}
+	reg, _ = candidates.resolve(i.lookup())
+	return
+}
+
+type StaticResolver map[string][]EndPoint
+
+func (r StaticResolver) Resolve(q Query) ([]EndPoint, error) {
+	if endPoints, ok := r[qualify(q.Namespace, q.Service)]; ok {
+		return endPoints, nil
+	}
+	// Unqualified keys predate namespaces and only name default services.
+	if q.Namespace == "" || q.Namespace == DefaultNamespace {
+		return r[q.Service], nil
+	}
+	return nil, nil
+}
+
+type FileResolver struct {
+	path      string
+	endPoints StaticResolver
+	modified  time.Time
+	size      int64
+	lock      *sync.RWMutex
+	done      context.Context
+	stop      context.CancelFunc
+}
+
+func NewFileResolver(path string, interval time.Duration) (r *FileResolver, err error) {
+	if interval <= 0 {
+		interval = DefaultFilePollInterval
+	}
+	done, stop := context.WithCancel(context.Background())
+	r = &FileResolver{
+		path: path,
+		lock: &sync.RWMutex{},
+		done: done,
+		stop: stop,
+	}
+	err = r.reload()
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	go r.watch(interval)
+	return
+}
+
+func (r *FileResolver) Resolve(q Query) ([]EndPoint, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.endPoints.Resolve(q)
+}
+
+func (r *FileResolver) Close() {
+	r.stop()
+}
+
+func (r *FileResolver) reload() (err error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return
+	}
+	r.lock.RLock()
+	unchanged := info.ModTime().Equal(r.modified) && info.Size() == r.size
+	r.lock.RUnlock()
+	if unchanged {
+		return
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return
+	}
+	endPoints, err := parseEndPoints(r.path, data)
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.endPoints = endPoints
+	r.modified = info.ModTime()
+	r.size = info.Size()
+	return
+}
+
+func (r *FileResolver) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done.Done():
+			return
+		case <-ticker.C:
+		}
+		// A broken edit keeps the last good endpoints until the file is fixed.
+		_ = r.reload()
+	}
+}
+
+func parseEndPoints(path string, data []byte) (endPoints StaticResolver, err error) {
+	// A file caught between truncation and write would otherwise drop every endpoint.
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, EmptyEndPointsFileError
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &endPoints)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&endPoints)
+	}
+	return
+}
+
+type DNSResolver struct {
+	domain   string
+	protocol string
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+func NewDNSResolver(domain string) DNSResolver {
+	return DNSResolver{
+		domain:   domain,
+		protocol: "tcp",
+		resolver: net.DefaultResolver,
+		timeout:  5 * time.Second,
+	}
+}
+
+func NewDNSResolverWithServer(domain, server string) DNSResolver {
+	r := NewDNSResolver(domain)
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	return r
+}
+
+func (r DNSResolver) Resolve(q Query) (endPoints []EndPoint, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	_, records, err := r.resolver.LookupSRV(ctx, strings.ToLower(q.Service), r.protocol, r.domain)
+	if err != nil {
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+	// Only the most preferred priority is used, as in RFC 2782.
+	priority := records[0].Priority
+	for _, record := range records {
+		if record.Priority < priority {
+			priority = record.Priority
+		}
+	}
+	for _, record := range records {
+		if record.Priority != priority {
+			continue
+		}
+		host := strings.TrimSuffix(record.Target, ".")
+		endPoints = append(endPoints, EndPoint{
+			Address: net.JoinHostPort(host, strconv.Itoa(int(record.Port))),
+			Labels: map[string]string{
+				WeightLabel:   strconv.Itoa(int(record.Weight)),
+				PriorityLabel: strconv.Itoa(int(record.Priority)),
+			},
+			Verified: true,
+		})
+	}
+	return
+}
@@ -0,0 +1,328 @@
+package monolith
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestInstance(t *testing.T, resolver Resolver, constraint, selector string) Instance {
+	t.Helper()
+	c, err := NewClient("test", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetLogger(NewStdLogger(log.New(io.Discard, "", 0), LevelError))
+	c.SetResolver(resolver)
+	i := Instance{
+		Type:   "Math",
+		client: &c,
+	}
+	i.constraint, err = ParseVersionConstraint(constraint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.selector, err = ParseSelector(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestResolveEndPoint(t *testing.T) {
+	resolver := StaticResolver{
+		"Math": {
+			{Address: "a:1", Version: "1.0.0", Labels: map[string]string{"zone": "a"}},
+			{Address: "b:1", Version: "v1.2", Labels: map[string]string{"zone": "b"}},
+			{Address: "c:1", Version: "2.0.0", Labels: map[string]string{"zone": "a"}},
+			{Address: "d:1", Version: "not a version"},
+		},
+	}
+	tests := []struct {
+		name       string
+		constraint string
+		selector   string
+		endPoint   string
+		version    string
+	}{
+		{name: "latest", endPoint: "c:1", version: "2.0.0"},
+		{name: "major constraint", constraint: "^1", endPoint: "b:1", version: "1.2.0"},
+		{name: "exact constraint", constraint: "1.0", endPoint: "a:1", version: "1.0.0"},
+		{name: "selector", constraint: "^1", selector: "zone=a", endPoint: "a:1", version: "1.0.0"},
+		{name: "no match", constraint: "^3"},
+		{name: "no label match", selector: "zone=c"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			i := newTestInstance(t, resolver, test.constraint, test.selector)
+			reg, err := i.resolveEndPoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reg.EndPoint != test.endPoint || reg.Version != test.version {
+				t.Fatalf("got %v %v, want %v %v", reg.EndPoint, reg.Version, test.endPoint, test.version)
+			}
+		})
+	}
+}
+
+func TestStaticResolverNamespaces(t *testing.T) {
+	resolver := StaticResolver{
+		"Math":         {{Address: "a:1"}},
+		"billing/Math": {{Address: "b:1"}},
+		"default/Sum":  {{Address: "c:1"}},
+	}
+	tests := []struct {
+		name      string
+		namespace string
+		service   string
+		address   string
+	}{
+		{name: "unqualified key", service: "Math", address: "a:1"},
+		{name: "unqualified key in the default namespace", namespace: DefaultNamespace, service: "Math", address: "a:1"},
+		{name: "qualified key", namespace: "billing", service: "Math", address: "b:1"},
+		{name: "qualified default key", service: "Sum", address: "c:1"},
+		{name: "other namespace ignores unqualified keys", namespace: "shipping", service: "Math"},
+		{name: "other namespace ignores default keys", namespace: "billing", service: "Sum"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endPoints, err := resolver.Resolve(Query{Namespace: test.namespace, Service: test.service})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var address string
+			if len(endPoints) > 0 {
+				address = endPoints[0].Address
+			}
+			if len(endPoints) > 1 || address != test.address {
+				t.Fatalf("got %+v, want %v", endPoints, test.address)
+			}
+		})
+	}
+}
+
+func TestParseEndPoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		data      string
+		endPoints StaticResolver
+		invalid   bool
+	}{
+		{
+			name:      "json",
+			path:      "endpoints.json",
+			data:      `{"Math": [{"address": "a:1", "version": "1.0.0", "labels": {"zone": "a"}}]}`,
+			endPoints: StaticResolver{"Math": {{Address: "a:1", Version: "1.0.0", Labels: map[string]string{"zone": "a"}}}},
+		},
+		{
+			name:      "yaml",
+			path:      "endpoints.yaml",
+			data:      "Math:\n  - address: a:1\n  - address: b:1\n    version: 2.0.0\n",
+			endPoints: StaticResolver{"Math": {{Address: "a:1"}, {Address: "b:1", Version: "2.0.0"}}},
+		},
+		{
+			name:      "yml",
+			path:      "endpoints.YML",
+			data:      "Math: [{address: a:1}]",
+			endPoints: StaticResolver{"Math": {{Address: "a:1"}}},
+		},
+		{
+			name:    "unknown json field",
+			path:    "endpoints.json",
+			data:    `{"Math": [{"address": "a:1", "weight": 3}]}`,
+			invalid: true,
+		},
+		{
+			name:    "empty file",
+			path:    "endpoints.yaml",
+			data:    " \n",
+			invalid: true,
+		},
+		{
+			name:    "broken json",
+			path:    "endpoints",
+			data:    `{"Math": [`,
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endPoints, err := parseEndPoints(test.path, []byte(test.data))
+			if test.invalid {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(endPoints, test.endPoints) {
+				t.Fatalf("got %+v, want %+v", endPoints, test.endPoints)
+			}
+		})
+	}
+}
+
+func TestFileResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addresses := func(r *FileResolver) string {
+		endPoints, _ := r.Resolve(Query{Service: "Math"})
+		var addresses []string
+		for _, e := range endPoints {
+			addresses = append(addresses, e.Address)
+		}
+		return strings.Join(addresses, ",")
+	}
+	write("Math: [{address: a:1}]")
+	r, err := NewFileResolver(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	steps := []struct {
+		data      string
+		addresses string
+	}{
+		{data: "Math: [{address: a:1}, {address: b:1}]", addresses: "a:1,b:1"},
+		{data: "Math: [{address: a:1}, {address", addresses: "a:1,b:1"},
+		{data: "Math: [{address: c:1}]", addresses: "c:1"},
+	}
+	for _, step := range steps {
+		write(step.data)
+		var got string
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if got = addresses(r); got == step.addresses {
+				break
+			}
+		}
+		if got != step.addresses {
+			t.Fatalf("after writing %q got %v, want %v", step.data, got, step.addresses)
+		}
+	}
+	if _, err := NewFileResolver(filepath.Join(t.TempDir(), "missing.json"), 0); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+type srvRecord struct {
+	priority, weight, port uint16
+	target                 string
+}
+
+func serveSRV(t *testing.T, records map[string][]srvRecord) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			query := buffer[:n]
+			end := 12
+			var labels []string
+			for query[end] != 0 {
+				labels = append(labels, string(query[end+1:end+1+int(query[end])]))
+				end += int(query[end]) + 1
+			}
+			end += 5
+			answers := records[strings.ToLower(strings.Join(labels, "."))]
+			var res []byte
+			res = append(res, query[:2]...)
+			res = binary.BigEndian.AppendUint16(res, 0x8180)
+			res = binary.BigEndian.AppendUint16(res, 1)
+			res = binary.BigEndian.AppendUint16(res, uint16(len(answers)))
+			res = append(res, 0, 0, 0, 0)
+			res = append(res, query[12:end]...)
+			for _, a := range answers {
+				var target []byte
+				for _, label := range strings.Split(a.target, ".") {
+					target = append(target, byte(len(label)))
+					target = append(target, label...)
+				}
+				target = append(target, 0)
+				res = append(res, 0xc0, 12, 0, 33, 0, 1, 0, 0, 0, 60)
+				res = binary.BigEndian.AppendUint16(res, uint16(6+len(target)))
+				res = binary.BigEndian.AppendUint16(res, a.priority)
+				res = binary.BigEndian.AppendUint16(res, a.weight)
+				res = binary.BigEndian.AppendUint16(res, a.port)
+				res = append(res, target...)
+			}
+			_, _ = conn.WriteTo(res, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolver(t *testing.T) {
+	server := serveSRV(t, map[string][]srvRecord{
+		"_math._tcp.example.test": {
+			{priority: 10, weight: 3, port: 4000, target: "a.example.test"},
+			{priority: 20, weight: 1, port: 4000, target: "c.example.test"},
+			{priority: 10, weight: 1, port: 4001, target: "b.example.test"},
+		},
+	})
+	r := NewDNSResolverWithServer("example.test.", server)
+	tests := []struct {
+		name      string
+		service   string
+		endPoints []EndPoint
+		invalid   bool
+	}{
+		{
+			name:    "lowest priority only",
+			service: "Math",
+			endPoints: []EndPoint{
+				{Address: "a.example.test:4000", Labels: map[string]string{WeightLabel: "3", PriorityLabel: "10"}, Verified: true},
+				{Address: "b.example.test:4001", Labels: map[string]string{WeightLabel: "1", PriorityLabel: "10"}, Verified: true},
+			},
+		},
+		{
+			name:    "no records",
+			service: "Strings",
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endPoints, err := r.Resolve(Query{Service: test.service})
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", endPoints)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(endPoints, func(i, j int) bool {
+				return endPoints[i].Address < endPoints[j].Address
+			})
+			if !reflect.DeepEqual(endPoints, test.endPoints) {
+				t.Fatalf("got %+v, want %+v", endPoints, test.endPoints)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package monolith
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type Schema struct {
+	Service string
+	Version string
+	ID      string
+	Methods []MethodSchema
+	Hash    string
+}
+
+type MethodSchema struct {
+	Name    string
+	Params  []ValueSchema
+	Results []ValueSchema
+}
+
+type ValueSchema struct {
+	Name string
+	Type string
+}
+
+var typeSchemas = make(map[serviceKey]Schema)
+
+var proxySchemas = make(map[reflect.Type]Schema)
+
+func RegisterTypeSchema(schema Schema) error {
+	version, err := canonicalVersion(schema.Version)
+	if err != nil {
+		return err
+	}
+	typeSchemas[serviceKey{schema.Service, version}] = schema
+	return nil
+}
+
+func RegisterProxySchema[T any](schema Schema) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	proxySchemas[t] = schema
+}
+
+func (s Schema) method(name string) (MethodSchema, bool) {
+	for _, m := range s.Methods {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return MethodSchema{}, false
+}
+
+func (s Schema) CheckCompatible(server Schema) error {
+	if s.Hash == "" || server.Hash == "" || s.Hash == server.Hash {
+		return nil
+	}
+	incompatible := func(format string, v ...any) error {
+		return IncompatibleSchemaError.withDetail("'%v' %v", s.Service, fmt.Sprintf(format, v...))
+	}
+	if s.ID != server.ID {
+		return incompatible("client uses instance ID %q, server uses %q", s.ID, server.ID)
+	}
+	for _, m := range s.Methods {
+		sm, ok := server.method(m.Name)
+		if !ok {
+			return incompatible("method %v is not provided by the server", m.Name)
+		}
+		if !equalValues(m.Params, sm.Params) {
+			return incompatible("method %v has parameters %v on the client and %v on the server",
+				m.Name, m.Params, sm.Params)
+		}
+		if len(m.Results) > len(sm.Results) || !equalValues(m.Results, sm.Results[:len(m.Results)]) {
+			return incompatible("method %v has results %v on the client and %v on the server",
+				m.Name, m.Results, sm.Results)
+		}
+	}
+	return nil
+}
+
+func equalValues(a, b []ValueSchema) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package monolith
+
+import (
+	"fmt"
+	"strings"
+)
+
+type requirement struct {
+	key   string
+	op    string
+	value string
+}
+
+type Selector struct {
+	text         string
+	requirements []requirement
+}
+
+func ParseSelector(s string) (selector Selector, err error) {
+	selector.text = strings.TrimSpace(s)
+	if selector.text == "" {
+		return
+	}
+	for _, field := range strings.Split(selector.text, ",") {
+		field = strings.TrimSpace(field)
+		var r requirement
+		switch {
+		case strings.Contains(field, "!="):
+			r.key, r.value, _ = strings.Cut(field, "!=")
+			r.op = "!="
+		case strings.Contains(field, "=="):
+			r.key, r.value, _ = strings.Cut(field, "==")
+			r.op = "="
+		case strings.Contains(field, "="):
+			r.key, r.value, _ = strings.Cut(field, "=")
+			r.op = "="
+		case strings.HasPrefix(field, "!"):
+			r.key = strings.TrimPrefix(field, "!")
+			r.op = "!"
+		default:
+			r.key = field
+			r.op = "exists"
+		}
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if r.key == "" {
+			return selector, fmt.Errorf("invalid label selector %q", s)
+		}
+		selector.requirements = append(selector.requirements, r)
+	}
+	return
+}
+
+func (s Selector) String() string {
+	return s.text
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s.requirements {
+		value, ok := labels[r.key]
+		switch r.op {
+		case "=":
+			if !ok || value != r.value {
+				return false
+			}
+		case "!=":
+			if ok && value == r.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
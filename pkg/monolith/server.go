@@ -2,37 +2,116 @@ package monolith
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/gob"
-	"fmt"
+	"errors"
 	"io"
-	"log"
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type TypeHandler func(
-	id string,
+	ctx context.Context,
+	id []byte,
 	method string,
 	decode func(params any) error,
 	encode func(results any) error) error
 
-var typeHandlers = make(map[string]TypeHandler)
+var typeHandlers = make(map[serviceKey]TypeHandler)
 
 func RegisterTypeHandler(name string, handler TypeHandler) {
-	typeHandlers[name] = handler
+	typeHandlers[serviceKey{name, ""}] = handler
+}
+
+func RegisterVersionedTypeHandler(name, version string, handler TypeHandler) error {
+	version, err := canonicalVersion(version)
+	if err != nil {
+		return err
+	}
+	typeHandlers[serviceKey{name, version}] = handler
+	return nil
+}
+
+func findTypeHandler(name, version string) (key serviceKey, handler TypeHandler, ok bool) {
+	key = serviceKey{name, version}
+	handler, ok = typeHandlers[key]
+	if ok || version != "" {
+		return
+	}
+	var latest Version
+	for k, h := range typeHandlers {
+		if k.Name != name {
+			continue
+		}
+		v, err := ParseVersion(k.Version)
+		if err != nil {
+			continue
+		}
+		if !ok || v.Compare(latest) > 0 {
+			key, handler, latest, ok = k, h, v, true
+		}
+	}
+	return
 }
 
 type Server struct {
-	name      string
-	listeners []*net.TCPListener
-	wgs       []*sync.WaitGroup
-	logger    *log.Logger
+	name            string
+	dependencies    SyncMap[string, []any]
+	singletons      SyncMap[serviceKey, *singleton]
+	labels          map[string]string
+	labelsLock      *sync.RWMutex
+	advertised      string
+	dispatcherOrder EndPointOrder
+	serving         *atomic.Bool
+	metrics         Metrics
+	exporter        SpanExporter
+	sampler         traceSampler
+	limits          *serverLimits
+	rates           *rateLimiter
+	replays         *replayCache
+	frames          FrameLimits
+	namespace       string
+	identity        string
+	secret          []byte
+	callerSecrets   map[string][]byte
+	announceTLS     *tls.Config
+	connections     SyncMap[string, connection]
+	status          *statusRecorder
+	listeners       []*net.TCPListener
+	statusServers   []*http.Server
+	statusToken     string
+	wgs             []*sync.WaitGroup
+	done            context.Context
+	stop            context.CancelFunc
+	logger          nodeLogger
 }
 
 func NewServer(name string) Server {
+	done, stop := context.WithCancel(context.Background())
+	var serving atomic.Bool
+	serving.Store(true)
+	status := newStatusRecorder()
 	return Server{
-		name:   name,
-		logger: log.Default(),
+		name:         name,
+		dependencies: NewSyncMap[string, []any](),
+		singletons:   NewSyncMap[serviceKey, *singleton](),
+		labels:       make(map[string]string),
+		labelsLock:   &sync.RWMutex{},
+		serving:      &serving,
+		metrics:      noMetrics{},
+		limits:       newServerLimits(DefaultConcurrencyLimits),
+		rates:        newRateLimiter(nil),
+		replays:      newReplayCache(),
+		frames:       DefaultFrameLimits,
+		connections:  NewSyncMap[string, connection](),
+		status:       status,
+		done:         done,
+		stop:         stop,
+		logger:       newNodeLogger("server", name, status),
 	}
 }
 
@@ -40,12 +119,54 @@ func (s *Server) Name() string {
 	return s.name
 }
 
+func (s *Server) SetLabel(key, value string) {
+	s.labelsLock.Lock()
+	defer s.labelsLock.Unlock()
+	s.labels[key] = value
+}
+
+func (s *Server) SetLabels(labels map[string]string) {
+	s.labelsLock.Lock()
+	defer s.labelsLock.Unlock()
+	for key, value := range labels {
+		s.labels[key] = value
+	}
+}
+
+func (s *Server) Labels() map[string]string {
+	s.labelsLock.RLock()
+	defer s.labelsLock.RUnlock()
+	labels := make(map[string]string, len(s.labels))
+	for key, value := range s.labels {
+		labels[key] = value
+	}
+	return labels
+}
+
+func (s *Server) Register(service string, dependencies ...any) error {
+	if _, _, ok := findTypeHandler(service, ""); !ok {
+		return UnregisteredTypeError
+	}
+	s.dependencies.put(service, dependencies)
+	return nil
+}
+
 func (s *Server) Stop() {
-	s.log("stopping...")
+	s.logger.info("stopping")
+	s.stop()
 	for _, listener := range s.listeners {
 		err := listener.Close()
 		if err != nil {
-			s.log(err)
+			s.logger.warn("failed to close listener", "error", err)
+		}
+	}
+	for _, c := range s.connections.values() {
+		_ = c.conn.SetReadDeadline(time.Now())
+	}
+	for _, server := range s.statusServers {
+		err := server.Close()
+		if err != nil {
+			s.logger.warn("failed to close status server", "error", err)
 		}
 	}
 }
@@ -54,7 +175,7 @@ func (s *Server) Wait() {
 	for _, wg := range s.wgs {
 		wg.Wait()
 	}
-	s.log("graceful shutdown complete.")
+	s.logger.info("graceful shutdown complete")
 }
 
 func (s *Server) Shutdown() {
@@ -62,22 +183,6 @@ func (s *Server) Shutdown() {
 	s.Wait()
 }
 
-func (s *Server) logf(format string, v ...any) {
-	if s.logger == nil {
-		return
-	}
-	message := fmt.Sprintf(format, v...)
-	s.logger.Printf("Server '%v': %v\n", s.name, message)
-}
-
-func (s *Server) log(v ...any) {
-	if s.logger == nil {
-		return
-	}
-	message := fmt.Sprint(v...)
-	s.logger.Printf("Server '%v': %v\n", s.name, message)
-}
-
 func (s *Server) Serve(endPoint string) (err error) {
 	local, err := net.ResolveTCPAddr("tcp", endPoint)
 	if err != nil {
@@ -87,7 +192,7 @@ func (s *Server) Serve(endPoint string) (err error) {
 	if err != nil {
 		return
 	}
-	s.log("started listening connections on ", endPoint)
+	s.logger.info("started listening connections", "endpoint", listener.Addr())
 	s.listeners = append(s.listeners, listener)
 	var wg sync.WaitGroup
 	s.wgs = append(s.wgs, &wg)
@@ -95,19 +200,21 @@ func (s *Server) Serve(endPoint string) (err error) {
 		for {
 			conn, err := listener.AcceptTCP()
 			if err != nil {
-				s.log(err)
+				if !errors.Is(err, net.ErrClosed) {
+					s.logger.error("failed to accept connection", "error", err)
+				}
 				return
 			}
 			remote := conn.RemoteAddr().String()
-			s.log("client connected from address ", remote)
+			s.logger.debug("client connected", "peer", remote)
 			wg.Add(1)
 			go func(conn net.Conn) {
 				defer wg.Done()
 				err := s.listen(conn, &wg)
 				if err != nil && err != io.EOF {
-					s.log(err)
+					s.logger.warn("client connection failed", "peer", remote, "error", err)
 				} else {
-					s.log("client ", remote, " disconnected")
+					s.logger.debug("client disconnected", "peer", remote)
 				}
 			}(conn)
 		}
@@ -115,83 +222,119 @@ func (s *Server) Serve(endPoint string) (err error) {
 	return
 }
 
-func (s *Server) AnnounceServices(serverEndPoint, announceEndPoint string) (err error) {
-	local, err := net.ResolveTCPAddr("tcp", serverEndPoint)
-	if err != nil {
-		return
+func (s *Server) Addr() net.Addr {
+	if len(s.listeners) == 0 {
+		return nil
 	}
-	remote, err := net.ResolveTCPAddr("tcp", announceEndPoint)
-	if err != nil {
-		return
+	return s.listeners[0].Addr()
+}
+
+func (s *Server) SetAdvertisedAddress(address string) {
+	s.advertised = address
+}
+
+func (s *Server) AdvertisedAddress() string {
+	if s.advertised != "" {
+		return s.advertised
 	}
-	conn, err := net.DialTCP("tcp", local, remote)
-	if err != nil {
-		return
+	if addr := s.Addr(); addr != nil {
+		return addr.String()
 	}
-	defer func() {
-		closeErr := conn.Close()
-		if closeErr != nil {
-			if err == nil {
-				err = closeErr
-			} else {
-				s.log(closeErr)
-			}
-		}
-	}()
-	s.log("connected to dispatcher ", announceEndPoint)
-	encoder := gob.NewEncoder(conn)
-	for service := range typeHandlers {
-		err = encoder.Encode(service)
-		if err != nil {
-			return
-		}
-		s.logf("successfully announced service '%v'", service)
-	}
-	return
+	return ""
 }
 
 func (s *Server) listen(conn net.Conn, wg *sync.WaitGroup) (err error) {
+	defer s.track(conn)()
+	ctx, cancel := context.WithCancel(context.Background())
+	var pending sync.WaitGroup
 	defer func() {
+		if s.done.Err() == nil {
+			cancel()
+		}
+		pending.Wait()
+		cancel()
 		closeErr := conn.Close()
 		if closeErr != nil {
 			if err == nil {
 				err = closeErr
 			} else {
-				s.log(closeErr)
+				s.logger.warn("failed to close connection", "error", closeErr)
 			}
 		}
 	}()
+	if s.done.Err() != nil {
+		return
+	}
 	remote := conn.RemoteAddr().String()
-	decoder := gob.NewDecoder(conn)
-	encoder := gob.NewEncoder(conn)
+	decoder := newFrameDecoder(conn, s.frames)
+	encoder := newFrameEncoder(conn, s.frames)
+	connectionLimit := newLimiter(s.limits.config.Connection, 0)
 	for {
 		var req request
-		err = decoder.Decode(&req)
+		var received int
+		received, err = decoder.decodeSized(&req)
+		if s.done.Err() != nil {
+			err = nil
+			return
+		}
 		if err != nil {
+			rejectFrame(encoder, err)
+			return
+		}
+		s.logger.request("received request", "service", req.Instance.Type, "method", req.Method,
+			"request_id", req.ID, "peer", remote, "caller", req.Caller)
+		// Stop reading until a request of this connection finishes, so a
+		// flooding client stalls in TCP instead of piling up goroutines.
+		if !connectionLimit.wait(s.done.Done()) {
 			return
 		}
-		s.log("received request with ID ", req.ID, " from client ", remote)
 		wg.Add(1)
-		go func(req request) {
+		pending.Add(1)
+		go func(req request, received int) {
 			defer wg.Done()
-			res := process(req)
+			defer pending.Done()
+			defer connectionLimit.release()
+			start := time.Now()
+			var res response
+			release, admitErr := s.admit(ctx, req)
+			if admitErr == nil {
+				finish := s.status.begin(req.Instance.Type + "." + req.Method)
+				res = s.process(ctx, req)
+				finish()
+				release()
+			} else {
+				res = response{
+					ID:  req.ID,
+					Err: admitErr,
+				}
+			}
+			elapsed := time.Since(start)
+			callErr := res.Err
 			if res.Err != nil {
-				s.log(res.Err)
-				res.Err = NewError(res.Err.Error())
+				s.logger.warn("request failed", "service", req.Instance.Type, "method", req.Method,
+					"request_id", req.ID, "peer", remote, "caller", req.Caller, "duration", elapsed, "error", res.Err)
+				res.Err = wireError(res.Err)
 			}
-			err = encoder.Encode(res)
+			sent, err := encodeResponse(encoder, res)
+			service, method := requestLabels(req, admitErr == nil, callErr)
+			s.observeRequest(service, method, received, sent, callErr, elapsed)
 			if err != nil {
-				s.log(err)
+				s.logger.error("failed to send response", "service", req.Instance.Type, "method", req.Method,
+					"request_id", req.ID, "peer", remote, "error", err)
 			} else {
-				s.log("successfully sent response with ID ", res.ID, " to client ", remote)
+				s.logger.request("sent response", "service", req.Instance.Type, "method", req.Method,
+					"request_id", req.ID, "peer", remote, "duration", elapsed)
 			}
-		}(req)
+		}(req, received)
 	}
 }
 
-func process(req request) (res response) {
+func (s *Server) process(ctx context.Context, req request) (res response) {
 	res.ID = req.ID
-	handler, ok := typeHandlers[req.Instance.Type]
+	if req.Instance.Type == HealthService {
+		return s.checkHealth(req)
+	}
+	key, handler, ok := findTypeHandler(req.Instance.Type, req.Instance.Version)
 	if !ok {
 		res.Err = UnregisteredTypeError
 		return
@@ -205,7 +348,31 @@ func process(req request) (res response) {
 	encode := func(results any) error {
 		return encoder.Encode(results)
 	}
-	res.Err = handler(req.Instance.ID, req.Method, decode, encode)
+	if !req.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, req.Deadline)
+		defer cancel()
+	}
+	ctx = withMetadata(ctx, req.Metadata)
+	ctx = withCaller(ctx, req.Caller)
+	parent, _ := ParseTraceParent(req.Metadata[TraceParentKey])
+	span, sc := startSpan(s.exporter, s.sampler, parent, ServerSpan, req.Instance.Type, req.Method)
+	if sc.IsValid() {
+		ctx = ContextWithSpanContext(ctx, sc)
+	}
+	span.set("request.id", req.ID)
+	defer func() {
+		exportErr := span.finish(res.Err)
+		if exportErr != nil {
+			s.logger.error("failed to export span", "error", exportErr)
+		}
+	}()
+	dependencies, _ := s.dependencies.get(req.Instance.Type)
+	ctx = withDependencies(ctx, dependencies)
+	ctx = withSingleton(ctx, s.singletons.getOrCreate(key, func() *singleton {
+		return &singleton{}
+	}))
+	res.Err = handler(ctx, req.Instance.ID, req.Method, decode, encode)
 	res.Results = buffer.Bytes()
 	return
 }
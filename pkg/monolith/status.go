@@ -0,0 +1,353 @@
+package monolith
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const recentErrorsSize = 32
+
+type ErrorRecord struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+type ServiceInfo struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+}
+
+type ServerStatus struct {
+	Name         string            `json:"name"`
+	Namespace    string            `json:"namespace"`
+	Address      string            `json:"address"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Serving      bool              `json:"serving"`
+	Started      time.Time         `json:"started"`
+	Uptime       string            `json:"uptime"`
+	Services     []ServiceInfo     `json:"services"`
+	Connections  []ConnectionInfo  `json:"connections"`
+	InFlight     map[string]int    `json:"inFlight"`
+	RecentErrors []ErrorRecord     `json:"recentErrors"`
+}
+
+type DispatcherStatus struct {
+	Name         string           `json:"name"`
+	Leader       string           `json:"leader,omitempty"`
+	Started      time.Time        `json:"started"`
+	Uptime       string           `json:"uptime"`
+	EndPoints    []EndPointInfo   `json:"endPoints"`
+	Connections  []ConnectionInfo `json:"connections"`
+	RecentErrors []ErrorRecord    `json:"recentErrors"`
+}
+
+type statusRecorder struct {
+	started  time.Time
+	lock     *sync.Mutex
+	inFlight map[string]int
+	errors   []ErrorRecord
+	next     int
+}
+
+func newStatusRecorder() *statusRecorder {
+	var lock sync.Mutex
+	return &statusRecorder{
+		started:  time.Now(),
+		lock:     &lock,
+		inFlight: make(map[string]int),
+	}
+}
+
+func (r *statusRecorder) uptime() string {
+	return time.Since(r.started).Round(time.Second).String()
+}
+
+func (r *statusRecorder) begin(method string) func() {
+	r.lock.Lock()
+	r.inFlight[method]++
+	r.lock.Unlock()
+	return func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.inFlight[method]--
+		if r.inFlight[method] == 0 {
+			delete(r.inFlight, method)
+		}
+	}
+}
+
+func (r *statusRecorder) recordError(message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	record := ErrorRecord{
+		Time:    time.Now(),
+		Message: message,
+	}
+	if len(r.errors) < recentErrorsSize {
+		r.errors = append(r.errors, record)
+		return
+	}
+	r.errors[r.next] = record
+	r.next = (r.next + 1) % recentErrorsSize
+}
+
+func (r *statusRecorder) snapshot() (inFlight map[string]int, recent []ErrorRecord) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	inFlight = make(map[string]int, len(r.inFlight))
+	for method, n := range r.inFlight {
+		inFlight[method] = n
+	}
+	recent = make([]ErrorRecord, 0, len(r.errors))
+	for i := len(r.errors) - 1; i >= 0; i-- {
+		recent = append(recent, r.errors[(r.next+i)%len(r.errors)])
+	}
+	return
+}
+
+func connectionInfos(connections SyncMap[string, connection]) []ConnectionInfo {
+	infos := []ConnectionInfo{}
+	for _, c := range connections.values() {
+		infos = append(infos, ConnectionInfo{
+			Kind:   c.kind,
+			Local:  c.conn.LocalAddr().String(),
+			Remote: c.conn.RemoteAddr().String(),
+			Since:  c.since,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Since.Before(infos[j].Since)
+	})
+	return infos
+}
+
+func (s *Server) Status() ServerStatus {
+	inFlight, recent := s.status.snapshot()
+	namespace := s.namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	services := []ServiceInfo{}
+	for key := range typeHandlers {
+		services = append(services, ServiceInfo{
+			Service: key.Name,
+			Version: key.Version,
+		})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Service != services[j].Service {
+			return services[i].Service < services[j].Service
+		}
+		return services[i].Version < services[j].Version
+	})
+	return ServerStatus{
+		Name:         s.name,
+		Namespace:    namespace,
+		Address:      s.AdvertisedAddress(),
+		Labels:       s.Labels(),
+		Serving:      s.serving.Load() && s.done.Err() == nil,
+		Started:      s.status.started,
+		Uptime:       s.status.uptime(),
+		Services:     services,
+		Connections:  connectionInfos(s.connections),
+		InFlight:     inFlight,
+		RecentErrors: recent,
+	}
+}
+
+func (d *Dispatcher) Status() DispatcherStatus {
+	_, recent := d.status.snapshot()
+	endPoints := d.endPointInfos("")
+	if endPoints == nil {
+		endPoints = []EndPointInfo{}
+	}
+	return DispatcherStatus{
+		Name:         d.name,
+		Leader:       d.Leader(),
+		Started:      d.status.started,
+		Uptime:       d.status.uptime(),
+		EndPoints:    endPoints,
+		Connections:  d.connectionInfos(),
+		RecentErrors: recent,
+	}
+}
+
+func (s *Server) SetStatusToken(token string) {
+	s.statusToken = token
+}
+
+func (d *Dispatcher) SetStatusToken(token string) {
+	d.statusToken = token
+}
+
+func (s *Server) StatusHandler() http.Handler {
+	return statusHandler(serverStatusPage, s.metrics, s.statusToken, func() any {
+		return s.Status()
+	})
+}
+
+func (d *Dispatcher) StatusHandler() http.Handler {
+	return statusHandler(dispatcherStatusPage, d.metrics, d.statusToken, func() any {
+		return d.Status()
+	})
+}
+
+func statusHandler(page *template.Template, metrics Metrics, token string, status func() any) http.Handler {
+	mux := http.NewServeMux()
+	if handler, ok := metrics.(http.Handler); ok {
+		mux.Handle("/metrics", handler)
+	}
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(status())
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = page.Execute(w, status())
+	})
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claimed := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(claimed, []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) ServeStatus(endPoint string) (err error) {
+	local, err := net.ResolveTCPAddr("tcp", endPoint)
+	if err != nil {
+		return
+	}
+	if s.statusToken == "" && !local.IP.IsLoopback() {
+		return InsecureStatusError
+	}
+	listener, err := net.ListenTCP("tcp", local)
+	if err != nil {
+		return
+	}
+	s.logger.info("started serving status page", "endpoint", listener.Addr())
+	server := &http.Server{
+		Handler: s.StatusHandler(),
+	}
+	s.statusServers = append(s.statusServers, server)
+	var wg sync.WaitGroup
+	s.wgs = append(s.wgs, &wg)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer s.logger.info("stopped serving status page", "endpoint", endPoint)
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.error("status page failed", "error", err)
+		}
+	}()
+	return
+}
+
+func (d *Dispatcher) ServeStatus(endPoint string) (err error) {
+	local, err := net.ResolveTCPAddr("tcp", endPoint)
+	if err != nil {
+		return
+	}
+	if d.statusToken == "" && !local.IP.IsLoopback() {
+		return InsecureStatusError
+	}
+	listener, err := net.ListenTCP("tcp", local)
+	if err != nil {
+		return
+	}
+	d.logger.info("started serving status page", "endpoint", listener.Addr())
+	server := &http.Server{
+		Handler: d.StatusHandler(),
+	}
+	d.statusServers = append(d.statusServers, server)
+	var wg sync.WaitGroup
+	d.wgs = append(d.wgs, &wg)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer d.logger.info("stopped serving status page", "endpoint", endPoint)
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			d.logger.error("status page failed", "error", err)
+		}
+	}()
+	return
+}
+
+const statusStyle = `<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #eee; }
+.bad { color: #b00; }
+</style>`
+
+const connectionsTable = `{{define "connections"}}
+<h2>Connections</h2>
+<table>
+<tr><th>Kind</th><th>Remote</th><th>Local</th><th>Since</th><th>Watching</th></tr>
+{{range .}}<tr><td>{{.Kind}}</td><td>{{.Remote}}</td><td>{{.Local}}</td><td>{{.Since.Format "2006-01-02 15:04:05"}}</td><td>{{range .Watching}}{{.}} {{end}}</td></tr>
+{{else}}<tr><td colspan="5">none</td></tr>
+{{end}}</table>
+{{end}}
+{{define "errors"}}
+<h2>Recent errors</h2>
+<table>
+<tr><th>Time</th><th>Error</th></tr>
+{{range .}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td class="bad">{{.Message}}</td></tr>
+{{else}}<tr><td colspan="2">none</td></tr>
+{{end}}</table>
+{{end}}`
+
+var serverStatusPage = template.Must(template.New("server").Parse(connectionsTable + `<!DOCTYPE html>
+<html><head><title>Server {{.Name}}</title>` + statusStyle + `</head><body>
+<h1>Server {{.Name}}</h1>
+<p>Namespace {{.Namespace}}, address {{.Address}}, {{if .Serving}}serving{{else}}<span class="bad">not serving</span>{{end}}, up {{.Uptime}} since {{.Started.Format "2006-01-02 15:04:05"}}. <a href="status.json">JSON</a></p>
+<h2>Services</h2>
+<table>
+<tr><th>Service</th><th>Version</th></tr>
+{{range .Services}}<tr><td>{{.Service}}</td><td>{{.Version}}</td></tr>
+{{else}}<tr><td colspan="2">none</td></tr>
+{{end}}</table>
+<h2>In-flight requests</h2>
+<table>
+<tr><th>Method</th><th>Requests</th></tr>
+{{range $method, $n := .InFlight}}<tr><td>{{$method}}</td><td>{{$n}}</td></tr>
+{{else}}<tr><td colspan="2">none</td></tr>
+{{end}}</table>
+{{template "connections" .Connections}}
+{{template "errors" .RecentErrors}}
+</body></html>`))
+
+var dispatcherStatusPage = template.Must(template.New("dispatcher").Parse(connectionsTable + `<!DOCTYPE html>
+<html><head><title>Dispatcher {{.Name}}</title>` + statusStyle + `</head><body>
+<h1>Dispatcher {{.Name}}</h1>
+<p>{{if .Leader}}Cluster leader {{.Leader}}, up{{else}}Up{{end}} {{.Uptime}} since {{.Started.Format "2006-01-02 15:04:05"}}. <a href="status.json">JSON</a></p>
+<h2>Endpoints</h2>
+<table>
+<tr><th>Namespace</th><th>Service</th><th>Version</th><th>Address</th><th>Healthy</th><th>Draining</th><th>Verified</th><th>Last seen</th><th>Labels</th></tr>
+{{range .EndPoints}}<tr><td>{{.Namespace}}</td><td>{{.Service}}</td><td>{{.Version}}</td><td>{{.Address}}</td><td{{if not .Healthy}} class="bad"{{end}}>{{.Healthy}}</td><td>{{.Draining}}</td><td>{{.Verified}}</td><td>{{if .LastSeen.IsZero}}never{{else}}{{.LastSeen.Format "2006-01-02 15:04:05"}}{{end}}</td><td>{{range $k, $v := .Labels}}{{$k}}={{$v}} {{end}}</td></tr>
+{{else}}<tr><td colspan="9">none</td></tr>
+{{end}}</table>
+{{template "connections" .Connections}}
+{{template "errors" .RecentErrors}}
+</body></html>`))
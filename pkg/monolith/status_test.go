@@ -0,0 +1,43 @@
+package monolith
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusToken(t *testing.T) {
+	d := NewDispatcher("d")
+	d.SetLogger(NewStdLogger(log.New(io.Discard, "", 0), LevelError))
+	defer d.Shutdown()
+	if err := d.ServeStatus("0.0.0.0:0"); err != InsecureStatusError {
+		t.Fatalf("got %v, want %v", err, InsecureStatusError)
+	}
+	d.SetStatusToken("secret")
+	tests := []struct {
+		name          string
+		authorization string
+		code          int
+	}{
+		{name: "missing token", code: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer guess", code: http.StatusUnauthorized},
+		{name: "token without scheme", authorization: "secret", code: http.StatusUnauthorized},
+		{name: "valid token", authorization: "Bearer secret", code: http.StatusOK},
+	}
+	handler := d.StatusHandler()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/status.json", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != test.code {
+				t.Fatalf("got %v, want %v", w.Code, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package monolith
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+const compactionThreshold = 1024
+
+type registryOp struct {
+	Kind         string
+	Service      string
+	Registration registration
+}
+
+const (
+	putOp    = "put"
+	removeOp = "remove"
+	syncOp   = "sync"
+)
+
+type store struct {
+	path    string
+	log     *os.File
+	records int
+	lock    sync.Mutex
+}
+
+func openStore(path string) (s *store, ops []registryOp, err error) {
+	snapshot, err := readOps(path)
+	if err != nil {
+		return
+	}
+	logged, err := readOps(path + ".log")
+	if err != nil {
+		return
+	}
+	s = &store{
+		path: path,
+	}
+	ops = append(snapshot, logged...)
+	return
+}
+
+func readLines(path string, read func(line []byte) error) (torn bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if torn {
+			// Only the last line can be torn by a crash; anything else would
+			// silently drop the records that follow.
+			return false, CorruptStoreError.withDetail("%v line %v", path, line-1)
+		}
+		torn = read(scanner.Bytes()) != nil
+	}
+	return torn, scanner.Err()
+}
+
+func readOps(path string) (ops []registryOp, err error) {
+	_, err = readLines(path, func(line []byte) error {
+		var op registryOp
+		err := json.Unmarshal(line, &op)
+		if err == nil {
+			ops = append(ops, op)
+		}
+		return err
+	})
+	return
+}
+
+func writeOps(path string, ops []registryOp) (err error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, op := range ops {
+		err = encoder.Encode(op)
+		if err != nil {
+			f.Close()
+			return
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *store) compact(state []registryOp) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err = writeOps(s.path, state)
+	if err != nil {
+		return
+	}
+	if s.log != nil {
+		_ = s.log.Close()
+	}
+	s.log, err = os.Create(s.path + ".log")
+	s.records = 0
+	return
+}
+
+func (s *store) append(op registryOp) (compact bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log == nil {
+		s.log, err = os.OpenFile(s.path+".log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			return
+		}
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+	_, err = s.log.Write(append(data, '\n'))
+	if err != nil {
+		return
+	}
+	err = s.log.Sync()
+	s.records++
+	return s.records >= compactionThreshold, err
+}
+
+func (s *store) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+type clusterState struct {
+	Term     uint64
+	VotedFor string
+}
+
+type clusterSnapshot struct {
+	Index uint64
+	Term  uint64
+	Ops   []registryOp
+}
+
+type storedEntry struct {
+	Index uint64
+	Entry logEntry
+}
+
+type clusterStore struct {
+	path string
+	log  *os.File
+	lock sync.Mutex
+}
+
+func openClusterStore(path string) (s *clusterStore, state clusterState, snapshot clusterSnapshot, entries []logEntry, err error) {
+	err = readJSON(path+".state", &state)
+	if err != nil {
+		return
+	}
+	err = readJSON(path+".snapshot", &snapshot)
+	if err != nil {
+		return
+	}
+	entries, torn, err := readEntries(path+".log", snapshot.Index)
+	if err != nil {
+		return
+	}
+	s = &clusterStore{
+		path: path,
+	}
+	if torn {
+		// Appending after a torn line would leave it in the middle of the log.
+		err = s.rewrite(snapshot.Index+1, entries)
+	}
+	return
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(path string, v any) (err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(tmp, path)
+}
+
+func readEntries(path string, snapshotIndex uint64) (entries []logEntry, torn bool, err error) {
+	gap := false
+	torn, err = readLines(path, func(line []byte) error {
+		var e storedEntry
+		err := json.Unmarshal(line, &e)
+		if err != nil || gap || e.Index <= snapshotIndex {
+			return err
+		}
+		position := e.Index - snapshotIndex - 1
+		if position > uint64(len(entries)) {
+			gap = true
+			return nil
+		}
+		entries = append(entries[:position], e.Entry)
+		return nil
+	})
+	return
+}
+
+func (s *clusterStore) saveState(state clusterState) error {
+	return writeJSON(s.path+".state", state)
+}
+
+func (s *clusterStore) append(first uint64, entries []logEntry) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log == nil {
+		s.log, err = os.OpenFile(s.path+".log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			return
+		}
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for i, e := range entries {
+		err = encoder.Encode(storedEntry{
+			Index: first + uint64(i),
+			Entry: e,
+		})
+		if err != nil {
+			return
+		}
+	}
+	_, err = s.log.Write(buffer.Bytes())
+	if err != nil {
+		return
+	}
+	return s.log.Sync()
+}
+
+func (s *clusterStore) rewrite(first uint64, entries []logEntry) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log != nil {
+		_ = s.log.Close()
+		s.log = nil
+	}
+	tmp := s.path + ".log.tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i, e := range entries {
+		err = encoder.Encode(storedEntry{
+			Index: first + uint64(i),
+			Entry: e,
+		})
+		if err != nil {
+			f.Close()
+			return
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(tmp, s.path+".log")
+}
+
+func (s *clusterStore) saveSnapshot(snapshot clusterSnapshot, entries []logEntry) error {
+	err := writeJSON(s.path+".snapshot", snapshot)
+	if err != nil {
+		return err
+	}
+	return s.rewrite(snapshot.Index+1, entries)
+}
+
+func (s *clusterStore) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
@@ -0,0 +1,103 @@
+package monolith
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadOps(t *testing.T) {
+	const (
+		a = `{"Kind":"put","Service":"Math","Registration":{"EndPoint":"a:1"}}`
+		b = `{"Kind":"put","Service":"Math","Registration":{"EndPoint":"b:1"}}`
+	)
+	tests := []struct {
+		name      string
+		data      string
+		endPoints string
+		corrupt   bool
+	}{
+		{name: "empty file"},
+		{name: "complete log", data: a + "\n" + b + "\n", endPoints: "a:1,b:1"},
+		{name: "torn last line", data: a + "\n" + b[:20], endPoints: "a:1"},
+		{name: "torn last line with newline", data: a + "\n" + b[:20] + "\n", endPoints: "a:1"},
+		{name: "corrupt line in the middle", data: a + "\n" + b[:20] + "\n" + b + "\n", corrupt: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "registry.log")
+			err := os.WriteFile(path, []byte(test.data), 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ops, err := readOps(path)
+			if test.corrupt {
+				if err == nil || ErrorCode(err) != "DataLoss" {
+					t.Fatalf("got %v, want a corrupt store error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var endPoints []string
+			for _, op := range ops {
+				endPoints = append(endPoints, op.Registration.EndPoint)
+			}
+			if got := strings.Join(endPoints, ","); got != test.endPoints {
+				t.Fatalf("got %v, want %v", got, test.endPoints)
+			}
+		})
+	}
+}
+
+func TestClusterStoreTornLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "raft")
+	s, _, _, _, err := openClusterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.append(1, []logEntry{putEntry(1, "x")})
+	if err == nil {
+		err = s.close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path+".log", os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString(`{"Index":2,"Ent`)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, endPoint := range []string{"y", "z"} {
+		s, _, _, entries, err := openClusterStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.append(uint64(len(entries)+1), []logEntry{putEntry(1, endPoint)})
+		if err == nil {
+			err = s.close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, _, entries, err := openClusterStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var endPoints []string
+	for _, e := range entries {
+		endPoints = append(endPoints, e.Op.Registration.EndPoint)
+	}
+	if got := strings.Join(endPoints, ","); got != "x,y,z" {
+		t.Fatalf("got entries %v, want x,y,z", got)
+	}
+}
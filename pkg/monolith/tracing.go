@@ -0,0 +1,281 @@
+package monolith
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TraceParentKey = "traceparent"
+
+const (
+	ClientSpan = "client"
+	ServerSpan = "server"
+)
+
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return validTraceHex(sc.TraceID, 32) && validTraceHex(sc.SpanID, 16)
+}
+
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID + "-" + sc.SpanID + "-" + flags
+}
+
+func ParseTraceParent(s string) (sc SpanContext, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[3]) != 2 {
+		err = InvalidTraceParentError
+		return
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		err = InvalidTraceParentError
+		return
+	}
+	flags, decodeErr := hex.DecodeString(parts[3])
+	if decodeErr != nil {
+		err = InvalidTraceParentError
+		return
+	}
+	sc = SpanContext{
+		TraceID: parts[1],
+		SpanID:  parts[2],
+		Sampled: flags[0]&1 == 1,
+	}
+	if !sc.IsValid() {
+		sc = SpanContext{}
+		err = InvalidTraceParentError
+	}
+	return
+}
+
+func validTraceHex(s string, length int) bool {
+	if len(s) != length || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID(bytes int) string {
+	id := make([]byte, bytes)
+	for {
+		_, _ = rand.Read(id)
+		s := hex.EncodeToString(id)
+		if strings.Trim(s, "0") != "" {
+			return s
+		}
+	}
+}
+
+type spanContextKey struct{}
+
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+type Span struct {
+	TraceID  string            `json:"traceId"`
+	SpanID   string            `json:"spanId"`
+	ParentID string            `json:"parentId,omitempty"`
+	Name     string            `json:"name"`
+	Kind     string            `json:"kind"`
+	Service  string            `json:"service"`
+	Method   string            `json:"method"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Duration time.Duration     `json:"duration"`
+	Code     string            `json:"code"`
+	Error    string            `json:"error,omitempty"`
+	Attrs    map[string]string `json:"attributes,omitempty"`
+}
+
+type SpanExporter interface {
+	Export(span Span) error
+}
+
+type activeSpan struct {
+	span     Span
+	exporter SpanExporter
+	sampled  bool
+}
+
+type traceSampler func(traceID string) bool
+
+func sampleRatio(ratio float64) traceSampler {
+	return func(traceID string) bool {
+		// Trace IDs are random, so their leading bits pick a stable share of
+		// traces that every node agrees on.
+		n, _ := strconv.ParseUint(traceID[:16], 16, 64)
+		return float64(n) < ratio*math.MaxUint64
+	}
+}
+
+func startSpan(exporter SpanExporter, sampler traceSampler, parent SpanContext, kind, service, method string) (*activeSpan, SpanContext) {
+	if exporter == nil && !parent.IsValid() {
+		return nil, SpanContext{}
+	}
+	sc := SpanContext{
+		TraceID: parent.TraceID,
+		SpanID:  newTraceID(8),
+		Sampled: parent.Sampled,
+	}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID(16)
+		sc.Sampled = sampler == nil || sampler(sc.TraceID)
+	}
+	return &activeSpan{
+		span: Span{
+			TraceID:  sc.TraceID,
+			SpanID:   sc.SpanID,
+			ParentID: parent.SpanID,
+			Name:     service + "." + method,
+			Kind:     kind,
+			Service:  service,
+			Method:   method,
+			Start:    time.Now(),
+			Attrs:    make(map[string]string),
+		},
+		exporter: exporter,
+		sampled:  sc.Sampled,
+	}, sc
+}
+
+func (a *activeSpan) set(key, value string) {
+	if a != nil {
+		a.span.Attrs[key] = value
+	}
+}
+
+func (a *activeSpan) finish(err error) error {
+	// Unsampled spans still propagate the trace, so downstream nodes skip it too.
+	if a == nil || a.exporter == nil || !a.sampled {
+		return nil
+	}
+	a.span.End = time.Now()
+	a.span.Duration = a.span.End.Sub(a.span.Start)
+	a.span.Code = ErrorCode(err)
+	if err != nil {
+		a.span.Error = err.Error()
+	}
+	return a.exporter.Export(a.span)
+}
+
+func (c *Client) SetSpanExporter(exporter SpanExporter) {
+	c.exporter = exporter
+}
+
+func (c *Client) SetTraceSampling(ratio float64) {
+	c.sampler = sampleRatio(ratio)
+}
+
+func (c *Client) traced(ctx context.Context) *Client {
+	// Proxies obtained through an injected client continue the trace of the
+	// request being handled; WithContext still takes precedence.
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return c
+	}
+	traced := *c
+	traced.spanContext = sc
+	return &traced
+}
+
+func (s *Server) SetSpanExporter(exporter SpanExporter) {
+	s.exporter = exporter
+}
+
+func (s *Server) SetTraceSampling(ratio float64) {
+	s.sampler = sampleRatio(ratio)
+}
+
+type InMemoryExporter struct {
+	spans []Span
+	lock  *sync.Mutex
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	var lock sync.Mutex
+	return &InMemoryExporter{
+		lock: &lock,
+	}
+}
+
+func (e *InMemoryExporter) Export(span Span) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func (e *InMemoryExporter) Spans() []Span {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = nil
+}
+
+type JSONLinesExporter struct {
+	file    *os.File
+	encoder *json.Encoder
+	lock    *sync.Mutex
+}
+
+func NewJSONLinesExporter(path string) (e *JSONLinesExporter, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return
+	}
+	var lock sync.Mutex
+	e = &JSONLinesExporter{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		lock:    &lock,
+	}
+	return
+}
+
+func (e *JSONLinesExporter) Export(span Span) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.encoder.Encode(span)
+}
+
+func (e *JSONLinesExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.file.Close()
+}
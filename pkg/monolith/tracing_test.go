@@ -0,0 +1,127 @@
+package monolith
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		sc      SpanContext
+		invalid bool
+	}{
+		{
+			name:   "sampled",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			sc:     SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+		},
+		{
+			name:   "not sampled",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			sc:     SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+		},
+		{
+			name:   "future version with extra fields",
+			header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra",
+			sc:     SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+		},
+		{name: "zero trace ID", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", invalid: true},
+		{name: "zero span ID", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", invalid: true},
+		{name: "uppercase", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", invalid: true},
+		{name: "forbidden version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", invalid: true},
+		{name: "version 00 with extra fields", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", invalid: true},
+		{name: "empty", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, err := ParseTraceParent(test.header)
+			if test.invalid {
+				if err != InvalidTraceParentError {
+					t.Fatalf("got %+v, %v, want %v", sc, err, InvalidTraceParentError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sc != test.sc {
+				t.Fatalf("got %+v, want %+v", sc, test.sc)
+			}
+			if sc.Sampled && !strings.HasSuffix(sc.TraceParent(), "-01") || !sc.Sampled && !strings.HasSuffix(sc.TraceParent(), "-00") {
+				t.Fatalf("traceparent %v lost the sampled flag", sc.TraceParent())
+			}
+		})
+	}
+}
+
+func TestSpanPropagation(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampler  traceSampler
+		exported int
+	}{
+		{name: "sampled", exported: 2},
+		{name: "all roots sampled", sampler: sampleRatio(1), exported: 2},
+		{name: "no roots sampled", sampler: sampleRatio(0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter := NewInMemoryExporter()
+			client, clientContext := startSpan(exporter, test.sampler, SpanContext{}, ClientSpan, "Math", "Add")
+			parent, err := ParseTraceParent(clientContext.TraceParent())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parent != clientContext {
+				t.Fatalf("traceparent round trip got %+v, want %+v", parent, clientContext)
+			}
+			// The server never samples roots itself, so only the parent decides.
+			server, serverContext := startSpan(exporter, sampleRatio(0), parent, ServerSpan, "Math", "Add")
+			if serverContext.TraceID != clientContext.TraceID || serverContext.SpanID == clientContext.SpanID {
+				t.Fatalf("server span %+v does not continue client span %+v", serverContext, clientContext)
+			}
+			if serverContext.Sampled != clientContext.Sampled {
+				t.Fatalf("server sampled %v, client sampled %v", serverContext.Sampled, clientContext.Sampled)
+			}
+			if err := server.finish(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.finish(RateLimitedError); err != nil {
+				t.Fatal(err)
+			}
+			spans := exporter.Spans()
+			if len(spans) != test.exported {
+				t.Fatalf("exported %v spans, want %v", len(spans), test.exported)
+			}
+			if test.exported == 0 {
+				return
+			}
+			serverSpan, clientSpan := spans[0], spans[1]
+			if clientSpan.ParentID != "" || clientSpan.Kind != ClientSpan || clientSpan.Code != "ResourceExhausted" {
+				t.Errorf("unexpected client span %+v", clientSpan)
+			}
+			if serverSpan.ParentID != clientSpan.SpanID || serverSpan.TraceID != clientSpan.TraceID || serverSpan.Kind != ServerSpan {
+				t.Errorf("server span %+v is not a child of %+v", serverSpan, clientSpan)
+			}
+		})
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	sampler := sampleRatio(0.25)
+	sampled := 0
+	for i := 0; i < 4000; i++ {
+		if sampler(newTraceID(16)) {
+			sampled++
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Fatalf("sampled %v of 4000 traces at a ratio of 0.25", sampled)
+	}
+	traceID := newTraceID(16)
+	if sampler(traceID) != sampler(traceID) {
+		t.Fatal("sampling is not stable for a trace")
+	}
+}
@@ -0,0 +1,185 @@
+package monolith
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func ParseVersion(s string) (v Version, err error) {
+	v, parts, err := parseVersionParts(s)
+	if err == nil && parts == 0 {
+		err = fmt.Errorf("invalid version %q", s)
+	}
+	return
+}
+
+func parseVersionParts(s string) (v Version, parts int, err error) {
+	s = strings.TrimPrefix(s, "v")
+	if s == "" {
+		return
+	}
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 {
+		err = fmt.Errorf("invalid version %q", s)
+		return
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, field := range fields {
+		if field == "x" || field == "*" {
+			break
+		}
+		*numbers[i], err = strconv.Atoi(field)
+		if err != nil || *numbers[i] < 0 {
+			err = fmt.Errorf("invalid version %q", s)
+			return
+		}
+		parts++
+	}
+	return
+}
+
+func canonicalVersion(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	v, err := ParseVersion(s)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%v.%v.%v", v.Major, v.Minor, v.Patch)
+}
+
+func (v Version) Compare(other Version) int {
+	switch {
+	case v.Major != other.Major:
+		return compareInts(v.Major, other.Major)
+	case v.Minor != other.Minor:
+		return compareInts(v.Minor, other.Minor)
+	default:
+		return compareInts(v.Patch, other.Patch)
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+type versionBound struct {
+	op      string
+	version Version
+}
+
+type VersionConstraint struct {
+	text   string
+	bounds []versionBound
+}
+
+func ParseVersionConstraint(s string) (c VersionConstraint, err error) {
+	c.text = strings.TrimSpace(s)
+	if c.text == "" || c.text == "latest" || c.text == "*" {
+		return
+	}
+	for _, field := range strings.Fields(c.text) {
+		op := strings.TrimRight(field, "0123456789.vx*")
+		v, parts, err := parseVersionParts(field[len(op):])
+		if err != nil {
+			return c, err
+		}
+		if parts == 0 {
+			if field == "x" || field == "*" {
+				continue
+			}
+			return c, fmt.Errorf("invalid version constraint %q: no version in %q", s, field)
+		}
+		bounds, err := expandBound(op, v, parts)
+		if err != nil {
+			return c, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+		c.bounds = append(c.bounds, bounds...)
+	}
+	return
+}
+
+func expandBound(op string, v Version, parts int) ([]versionBound, error) {
+	next := func(parts int) Version {
+		switch parts {
+		case 1:
+			return Version{Major: v.Major + 1}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1}
+		default:
+			return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+		}
+	}
+	switch op {
+	case "", "=":
+		return []versionBound{{">=", v}, {"<", next(parts)}}, nil
+	case "^":
+		switch {
+		case v.Major != 0 || parts == 1:
+			return []versionBound{{">=", v}, {"<", next(1)}}, nil
+		case v.Minor != 0 || parts == 2:
+			return []versionBound{{">=", v}, {"<", next(2)}}, nil
+		default:
+			return []versionBound{{">=", v}, {"<", next(3)}}, nil
+		}
+	case "~":
+		if parts == 1 {
+			return []versionBound{{">=", v}, {"<", next(1)}}, nil
+		}
+		return []versionBound{{">=", v}, {"<", next(2)}}, nil
+	case ">", ">=", "<", "<=":
+		if op == ">" && parts < 3 {
+			return []versionBound{{">=", next(parts)}}, nil
+		}
+		if op == "<=" && parts < 3 {
+			return []versionBound{{"<", next(parts)}}, nil
+		}
+		return []versionBound{{op, v}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+func (c VersionConstraint) String() string {
+	if c.text == "" {
+		return "latest"
+	}
+	return c.text
+}
+
+func (c VersionConstraint) Matches(v Version) bool {
+	for _, b := range c.bounds {
+		cmp := v.Compare(b.version)
+		ok := false
+		switch b.op {
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
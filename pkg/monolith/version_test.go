@@ -0,0 +1,69 @@
+package monolith
+
+import "testing"
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+		invalid    bool
+	}{
+		{constraint: "", matches: []string{"0.0.1", "9.0.0"}},
+		{constraint: "latest", matches: []string{"1.0.0"}},
+		{constraint: "*", matches: []string{"1.0.0"}},
+		{constraint: "x", matches: []string{"1.0.0"}},
+		{constraint: "1", matches: []string{"1.0.0", "1.9.9"}, rejects: []string{"0.9.0", "2.0.0"}},
+		{constraint: "1.2.x", matches: []string{"1.2.0", "1.2.7"}, rejects: []string{"1.3.0"}},
+		{constraint: "=1.2.3", matches: []string{"1.2.3"}, rejects: []string{"1.2.4"}},
+		{constraint: "^1.2", matches: []string{"1.2.0", "1.9.0"}, rejects: []string{"1.1.9", "2.0.0"}},
+		{constraint: "^0.2.3", matches: []string{"0.2.3", "0.2.9"}, rejects: []string{"0.3.0"}},
+		{constraint: "~1.2.3", matches: []string{"1.2.3", "1.2.9"}, rejects: []string{"1.3.0"}},
+		{constraint: ">1.2", matches: []string{"1.3.0"}, rejects: []string{"1.2.9"}},
+		{constraint: "<=1.2", matches: []string{"1.2.9"}, rejects: []string{"1.3.0"}},
+		{constraint: ">=1.2 <2", matches: []string{"1.2.0", "1.9.9"}, rejects: []string{"1.1.0", "2.0.0"}},
+		{constraint: "v1.2", matches: []string{"1.2.5"}, rejects: []string{"1.3.0"}},
+		{constraint: "foo", invalid: true},
+		{constraint: ">=", invalid: true},
+		{constraint: "banana 1", invalid: true},
+		{constraint: "1 >", invalid: true},
+		{constraint: "v", invalid: true},
+		{constraint: "!1.2", invalid: true},
+		{constraint: "=>1.2", invalid: true},
+		{constraint: "1.2.3.4", invalid: true},
+		{constraint: "1.a", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.constraint, func(t *testing.T) {
+			c, err := ParseVersionConstraint(test.constraint)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range test.matches {
+				if !c.Matches(mustParseVersion(t, v)) {
+					t.Errorf("%v does not match %v", v, test.constraint)
+				}
+			}
+			for _, v := range test.rejects {
+				if c.Matches(mustParseVersion(t, v)) {
+					t.Errorf("%v matches %v", v, test.constraint)
+				}
+			}
+		})
+	}
+}
+
+func mustParseVersion(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := ParseVersion(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
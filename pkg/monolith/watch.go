@@ -0,0 +1,348 @@
+package monolith
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	watchBuffer      = 256
+	watchSyncTimeout = 2 * time.Second
+)
+
+type watcher struct {
+	conn     net.Conn
+	services map[string]bool
+	lock     sync.Mutex
+	out      chan dispatcherMessage
+	done     chan struct{}
+}
+
+func newWatcher(conn net.Conn) *watcher {
+	return &watcher{
+		conn:     conn,
+		services: make(map[string]bool),
+		out:      make(chan dispatcherMessage, watchBuffer),
+		done:     make(chan struct{}),
+	}
+}
+
+func (w *watcher) send(m dispatcherMessage) {
+	select {
+	case w.out <- m:
+	case <-w.done:
+	default:
+		// The client is too slow to keep up; it resynchronises after reconnecting.
+		_ = w.conn.Close()
+	}
+}
+
+func (w *watcher) write(encoder *frameEncoder) error {
+	for {
+		select {
+		case m := <-w.out:
+			err := encoder.Encode(m)
+			if err != nil {
+				return err
+			}
+		case <-w.done:
+			return nil
+		}
+	}
+}
+
+func (w *watcher) watches(service string, namespaces []string, allNamespaces bool) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if allNamespaces {
+		for name := range w.services {
+			if _, s := splitQualified(name); s == service {
+				return true
+			}
+		}
+		return false
+	}
+	for _, namespace := range namespaces {
+		if w.services[qualify(namespace, service)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watcher) subscriptions() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var names []string
+	for name := range w.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (w *watcher) unsubscribe(m watch) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.services, qualify(m.Namespace, m.Service))
+}
+
+func (d *Dispatcher) subscribe(w *watcher, m watch) {
+	d.applyLock.Lock()
+	defer d.applyLock.Unlock()
+	w.lock.Lock()
+	w.services[qualify(m.Namespace, m.Service)] = true
+	w.lock.Unlock()
+	regs := d.registrations(m.Namespace, m.Service)
+	d.countLookup(m.Namespace, m.Service, len(regs) != 0)
+	w.send(dispatcherMessage{
+		Event: &watchEvent{
+			Service:       m.Service,
+			Kind:          syncOp,
+			Registrations: regs,
+		},
+	})
+}
+
+func (d *Dispatcher) notify(op registryOp) {
+	if op.Kind == putOp && !d.services.isAvailable(op.Registration) {
+		op.Kind = removeOp
+	}
+	namespaces, allNamespaces := d.audience(op.Service)
+	_, service := splitQualified(op.Service)
+	for _, w := range d.watchers.values() {
+		if !w.watches(service, namespaces, allNamespaces) {
+			continue
+		}
+		w.send(dispatcherMessage{
+			Event: &watchEvent{
+				Service:       service,
+				Kind:          op.Kind,
+				Registrations: []registration{op.Registration},
+			},
+		})
+	}
+}
+
+type watchSession struct {
+	conn      net.Conn
+	encoder   *frameEncoder
+	endPoints registry
+	synced    map[string]chan struct{}
+	lock      *sync.Mutex
+}
+
+func newWatchSession() *watchSession {
+	return &watchSession{
+		endPoints: newRegistry(),
+		synced:    make(map[string]chan struct{}),
+		lock:      &sync.Mutex{},
+	}
+}
+
+func (ws *watchSession) endPoint(c *Client, i Instance) (reg registration, err error) {
+	synced, err := ws.subscribe(c, i.Type)
+	if err != nil {
+		return
+	}
+	select {
+	case <-synced:
+	case <-time.After(watchSyncTimeout):
+		ws.unsubscribe(c, i.Type, synced)
+		err = WatchTimeoutError
+		return
+	}
+	reg, ok := ws.endPoints.resolve(i.lookup())
+	if !ok {
+		err = ServiceNotFoundError
+	}
+	return
+}
+
+func (ws *watchSession) subscribe(c *Client, service string) (synced chan struct{}, err error) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	synced, ok := ws.synced[service]
+	if ok {
+		return
+	}
+	if ws.conn == nil {
+		err = ws.connect(c)
+		if err != nil {
+			return
+		}
+	}
+	err = ws.encoder.Encode(clientMessage{
+		Watch: &watch{
+			Namespace: c.namespace,
+			Service:   service,
+		},
+	})
+	if err != nil {
+		_ = ws.conn.Close()
+		return
+	}
+	synced = make(chan struct{})
+	ws.synced[service] = synced
+	return
+}
+
+func (ws *watchSession) unsubscribe(c *Client, service string, synced chan struct{}) {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if ws.synced[service] != synced {
+		return
+	}
+	select {
+	case <-synced:
+		return
+	default:
+	}
+	// A later call subscribes again instead of waiting on a sync that never came.
+	delete(ws.synced, service)
+	ws.endPoints.replace(service, nil)
+	if ws.conn == nil {
+		return
+	}
+	err := ws.encoder.Encode(clientMessage{
+		Unwatch: &watch{
+			Namespace: c.namespace,
+			Service:   service,
+		},
+	})
+	if err != nil {
+		_ = ws.conn.Close()
+	}
+}
+
+func (ws *watchSession) connect(c *Client) error {
+	return c.dispatchers.try(func(endPoint string) (err error) {
+		dispatcherAddress, err := net.ResolveTCPAddr("tcp", endPoint)
+		if err != nil {
+			return
+		}
+		conn, err := net.DialTCP("tcp", c.address, dispatcherAddress)
+		if err != nil {
+			c.logger.warn("dispatcher failed", "dispatcher", endPoint, "error", err)
+			return
+		}
+		encoder := newFrameEncoder(conn, c.frames)
+		for service := range ws.synced {
+			err = encoder.Encode(clientMessage{
+				Watch: &watch{
+					Namespace: c.namespace,
+					Service:   service,
+				},
+			})
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+		}
+		c.logger.info("watching services", "dispatcher", endPoint)
+		ws.conn = conn
+		ws.encoder = encoder
+		go ws.read(c, conn, endPoint)
+		return
+	})
+}
+
+func (ws *watchSession) read(c *Client, conn net.Conn, endPoint string) {
+	decoder := newFrameDecoder(conn, c.frames)
+	for {
+		var m dispatcherMessage
+		err := decoder.Decode(&m)
+		if err != nil {
+			break
+		}
+		if m.Event != nil {
+			ws.handle(c, *m.Event)
+		}
+	}
+	_ = conn.Close()
+	ws.lock.Lock()
+	if ws.conn == conn {
+		ws.conn = nil
+		ws.encoder = nil
+	}
+	ws.lock.Unlock()
+	if c.done.Err() != nil {
+		return
+	}
+	c.logger.warn("lost watch connection", "dispatcher", endPoint)
+	c.dispatchers.markFailed(endPoint)
+	ws.reconnect(c)
+}
+
+func (ws *watchSession) reconnect(c *Client) {
+	backoff := minAnnounceBackoff
+	for {
+		var err error
+		ws.lock.Lock()
+		if ws.conn == nil {
+			err = ws.connect(c)
+		}
+		ws.lock.Unlock()
+		if err == nil {
+			return
+		}
+		select {
+		case <-c.done.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxAnnounceBackoff {
+			backoff = maxAnnounceBackoff
+		}
+	}
+}
+
+func (ws *watchSession) handle(c *Client, e watchEvent) {
+	switch e.Kind {
+	case syncOp:
+		ws.endPoints.replace(e.Service, e.Registrations)
+		present := make(map[string]bool)
+		for _, reg := range e.Registrations {
+			present[reg.EndPoint] = true
+		}
+		c.retireRoutes(func(r route) bool {
+			return r.service == e.Service && !present[r.endPoint]
+		})
+		ws.lock.Lock()
+		synced := ws.synced[e.Service]
+		ws.lock.Unlock()
+		if synced != nil {
+			select {
+			case <-synced:
+			default:
+				close(synced)
+			}
+		}
+	case putOp:
+		for _, reg := range e.Registrations {
+			ws.endPoints.put(e.Service, reg)
+			c.logger.debug("dispatcher added service", "service", e.Service, "version", reg.Version, "endpoint", reg.EndPoint)
+		}
+	case removeOp:
+		for _, reg := range e.Registrations {
+			if !ws.endPoints.remove(e.Service, reg) {
+				continue
+			}
+			c.logger.debug("dispatcher removed service", "service", e.Service, "version", reg.Version, "endpoint", reg.EndPoint)
+			c.retireRoutes(func(r route) bool {
+				return r.service == e.Service && r.endPoint == reg.EndPoint
+			})
+		}
+	}
+}
+
+func (ws *watchSession) close() {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if ws.conn != nil {
+		_ = ws.conn.Close()
+	}
+}